@@ -9,7 +9,7 @@ buildGoModule {
 
   src = ./..;
 
-  vendorHash = "sha256-Fn97FYYAKqzK941150b6d9OCW7Gi4sFQ1T0KIVuGMMQ=";
+  vendorHash = "sha256-YVfIWLkwg0d+f6dUYRjBr3/IKS6Kl46Tdm0wCQipsMg=";
 
   env.CGO_ENABLED = "1";
 
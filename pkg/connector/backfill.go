@@ -24,6 +24,7 @@ import (
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
 
 	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
 	"go.mau.fi/mautrix-simplex/pkg/simplexid"
@@ -52,6 +53,11 @@ func (s *SimplexClient) FetchMessages(ctx context.Context, params bridgev2.Fetch
 			ItemID: anchorItemID,
 			Count:  params.Count,
 		}
+		if params.Forward {
+			// Fill the gap after the newest bridged message, e.g. when the
+			// bridge requests forward backfill for an existing portal.
+			pagination.Type = simplexclient.PaginationAfter
+		}
 	} else {
 		pagination = simplexclient.ChatPagination{
 			Type:  simplexclient.PaginationLast,
@@ -68,9 +74,19 @@ func (s *SimplexClient) FetchMessages(ctx context.Context, params bridgev2.Fetch
 		return nil, nil
 	}
 
+	batchItemIDs := make(map[int64]struct{}, len(chat.ChatItems))
+	for _, item := range chat.ChatItems {
+		if item.Meta.ItemDeleted == nil {
+			batchItemIDs[item.Meta.ItemID] = struct{}{}
+		}
+	}
 	convertedMessages := make([]*bridgev2.BackfillMessage, 0, len(chat.ChatItems))
 	for i := range chat.ChatItems {
 		item := &chat.ChatItems[i]
+		if item.Meta.ItemDeleted != nil {
+			// Deleted messages are simply left out of the history.
+			continue
+		}
 		msgID := simplexid.MakeMessageID(item.Meta.ItemID)
 		ts := parseSimplexTime(item.Meta.CreatedAt)
 		sender := s.makeEventSenderFromDir(item.ChatDir)
@@ -78,16 +94,10 @@ func (s *SimplexClient) FetchMessages(ctx context.Context, params bridgev2.Fetch
 			sender = s.makeEventSenderFromContact(chat.ChatInfo.Contact)
 		}
 
-		cm := convertChatItemToMatrix(item)
-
-		var reactions []*bridgev2.BackfillReaction
-		for _, reaction := range item.Reactions {
-			if reaction.Reaction.Type != "emoji" {
-				continue
-			}
-			// We don't have per-reactor data in CIReactionCount, skip individuals.
-			_ = reaction
-		}
+		cm := s.convertBackfillChatItem(ctx, params.Portal, sender, item)
+		s.addQuoteFallback(ctx, params.Portal, cm, item, batchItemIDs)
+		addForwardedHeader(cm, item)
+		addEditedMarker(cm, item)
 
 		convertedMessages = append(convertedMessages, &bridgev2.BackfillMessage{
 			ConvertedMessage: cm,
@@ -96,20 +106,24 @@ func (s *SimplexClient) FetchMessages(ctx context.Context, params bridgev2.Fetch
 			TxnID:            networkid.TransactionID(msgID),
 			Timestamp:        ts,
 			StreamOrder:      ts.UnixMilli(),
-			Reactions:        reactions,
+			Reactions:        s.getBackfillReactions(ctx, &chat.ChatInfo, item),
 		})
 	}
 
 	hasMore := len(chat.ChatItems) >= params.Count
+	// Only the latest messages say anything about the chat's read state.
+	markRead := (params.Forward || params.AnchorMessage == nil) &&
+		chat.ChatStats.UnreadCount == 0 && !chat.ChatStats.UnreadChat
 	return &bridgev2.FetchMessagesResponse{
 		Messages:         convertedMessages,
 		HasMore:          hasMore,
-		Forward:          false,
-		MarkRead:         false,
+		Forward:          params.Forward,
+		MarkRead:         markRead,
 		ApproxTotalCount: 0,
 		CompleteCallback: func() {
 			zerolog.Ctx(ctx).Debug().
 				Int("count", len(convertedMessages)).
+				Bool("forward", params.Forward).
 				Time("oldest", func() time.Time {
 					if len(convertedMessages) > 0 {
 						return convertedMessages[0].Timestamp
@@ -120,3 +134,120 @@ func (s *SimplexClient) FetchMessages(ctx context.Context, params bridgev2.Fetch
 		},
 	}, nil
 }
+
+// convertBackfillChatItem converts a backfilled chat item, uploading its file
+// if it has been downloaded. Files that were never accepted are downloaded if
+// they're small enough and allowed by the file policy, otherwise they're
+// bridged as a notice so they can be downloaded on demand.
+func (s *SimplexClient) convertBackfillChatItem(ctx context.Context, portal *bridgev2.Portal, sender bridgev2.EventSender, item *simplexclient.ChatItem) *bridgev2.ConvertedMessage {
+	intent, ok := portal.GetIntentFor(ctx, sender, s.UserLogin, bridgev2.RemoteEventMessage)
+	if !ok {
+		// Without an intent the file can't be uploaded, so the internal file
+		// path must not end up in the event content.
+		cm := convertChatItemToMatrix(item)
+		for _, part := range cm.Parts {
+			if _, ok := part.Extra["fi.mau.simplex.file_path"]; ok {
+				delete(part.Extra, "fi.mau.simplex.file_path")
+				notice := "[File transfer failed: couldn't upload " + item.File.FileName + "]"
+				if caption := fileCaption(item); caption != "" {
+					notice = caption + "\n\n" + notice
+				}
+				part.Content = &event.MessageEventContent{
+					MsgType: event.MsgNotice,
+					Body:    notice,
+				}
+			}
+		}
+		return cm
+	}
+	cm := s.convertChatItem(ctx, portal, intent, item)
+	file := item.File
+	if file == nil || file.GetFilePath() != "" || item.Meta.ItemDeleted != nil ||
+		!s.Main.canDownloadFile(file.FileSize) || file.GetStatus().Type != "rcvInvitation" {
+		return cm
+	}
+	maxSize := s.Main.Config.FilePolicy.BackfillMaxSize
+	if allowed, _ := s.Main.getFilePolicy(portal).allows(file.FileName, file.FileSize); allowed && maxSize > 0 && file.FileSize <= maxSize {
+		// The message is edited to the file when the download completes.
+		err := s.Client.ReceiveFile(file.FileID)
+		if err == nil {
+			return cm
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Int64("file_id", file.FileID).Msg("Failed to download backfilled file")
+	}
+	cm.Parts = []*bridgev2.ConvertedMessagePart{pendingFilePart(file, fileCaption(item))}
+	return cm
+}
+
+// getBackfillReactions returns the reactions to a backfilled chat item. Group
+// reactions are attributed to members with the reaction members API, while
+// in direct chats each side can only react once per emoji.
+func (s *SimplexClient) getBackfillReactions(ctx context.Context, chatInfo *simplexclient.ChatInfo, item *simplexclient.ChatItem) []*bridgev2.BackfillReaction {
+	if len(item.Reactions) == 0 {
+		return nil
+	}
+	log := zerolog.Ctx(ctx)
+	userID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
+	ownSender := bridgev2.EventSender{IsFromMe: true, Sender: simplexid.MakeUserID(userID)}
+	var reactions []*bridgev2.BackfillReaction
+	for _, reaction := range item.Reactions {
+		if reaction.Reaction.Type != "emoji" {
+			continue
+		}
+		emoji := reaction.Reaction.Emoji
+		if reaction.UserReacted {
+			reactions = append(reactions, &bridgev2.BackfillReaction{Sender: ownSender, EmojiID: networkid.EmojiID(emoji), Emoji: emoji})
+		}
+		switch {
+		case chatInfo.Type == "direct" && chatInfo.Contact != nil:
+			if reaction.TotalReacted > 1 || (reaction.TotalReacted == 1 && !reaction.UserReacted) {
+				reactions = append(reactions, &bridgev2.BackfillReaction{
+					Sender:  s.makeEventSenderFromContact(chatInfo.Contact),
+					EmojiID: networkid.EmojiID(emoji),
+					Emoji:   emoji,
+				})
+			}
+		case chatInfo.Type == "group" && chatInfo.GroupInfo != nil:
+			members, err := s.Client.GetReactionMembers(userID, chatInfo.GroupInfo.GroupID, item.Meta.ItemID, emoji)
+			if err != nil {
+				log.Warn().Err(err).Int64("item_id", item.Meta.ItemID).Msg("Failed to get reaction members")
+				continue
+			}
+			for _, member := range members {
+				if member.GroupMember.MemberID == chatInfo.GroupInfo.Membership.MemberID {
+					continue
+				}
+				reactions = append(reactions, &bridgev2.BackfillReaction{
+					Timestamp: parseSimplexTime(member.ReactionTs),
+					Sender:    s.makeEventSenderFromMember(&member.GroupMember),
+					EmojiID:   networkid.EmojiID(emoji),
+					Emoji:     emoji,
+				})
+			}
+		}
+	}
+	return reactions
+}
+
+// addEditedMarker marks a backfilled message that was edited on SimpleX, as
+// the history only contains its latest version.
+func addEditedMarker(cm *bridgev2.ConvertedMessage, item *simplexclient.ChatItem) {
+	if !item.Meta.ItemEdited || item.Meta.ItemDeleted != nil {
+		return
+	}
+	part := firstMessagePart(cm)
+	if part == nil {
+		return
+	}
+	content := part.Content
+	switch content.MsgType {
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		if content.FileName == "" || content.Body == content.FileName {
+			// Media without a caption gets the marker as its caption.
+			content.FileName, content.Body = content.Body, ""
+			appendToContent(content, "(edited)", "<em>(edited)</em>")
+			return
+		}
+	}
+	appendToContent(content, " (edited)", " <em>(edited)</em>")
+}
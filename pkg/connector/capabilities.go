@@ -24,11 +24,12 @@ import (
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
 
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
 	"go.mau.fi/mautrix-simplex/pkg/simplexid"
 )
 
 var simplexCaps = &event.RoomFeatures{
-	ID: "fi.mau.simplex.capabilities.2024",
+	ID: "fi.mau.simplex.capabilities.2026_10_18",
 
 	Formatting: map[event.FormattingFeature]event.CapabilitySupportLevel{
 		event.FmtBold:          event.CapLevelFullySupported,
@@ -74,24 +75,59 @@ var simplexCaps = &event.RoomFeatures{
 	Edit:   event.CapLevelFullySupported,
 	Delete: event.CapLevelFullySupported,
 
+	DeleteChat:            true,
+	DeleteChatForEveryone: true,
+
 	Reaction:         event.CapLevelFullySupported,
 	ReactionCount:    -1,
-	AllowedReactions: nil, // all emoji allowed
+	AllowedReactions: nil, // restricted by makeCapabilities if unsupported reactions are rejected
 }
 
 var simplexCapsDM *event.RoomFeatures
 
+// simplexCapsNotes are the capabilities of the local notes chat, which only
+// exists locally and doesn't support reactions.
+var simplexCapsNotes *event.RoomFeatures
+
 func init() {
 	simplexCapsDM = &event.RoomFeatures{}
 	*simplexCapsDM = *simplexCaps
-	simplexCapsDM.ID = "fi.mau.simplex.capabilities.2024+dm"
+	simplexCapsDM.ID = "fi.mau.simplex.capabilities.2026_10_18+dm"
+
+	simplexCapsNotes = &event.RoomFeatures{}
+	*simplexCapsNotes = *simplexCaps
+	simplexCapsNotes.ID = "fi.mau.simplex.capabilities.2026_10_18+notes"
+	simplexCapsNotes.Reaction = event.CapLevelRejected
+	simplexCapsNotes.ReactionCount = 0
+	simplexCapsNotes.DeleteChatForEveryone = false
 }
 
 func (s *SimplexClient) GetCapabilities(ctx context.Context, portal *bridgev2.Portal) *event.RoomFeatures {
+	if chatType, _, _ := simplexid.ParsePortalID(portal.ID); chatType == simplexclient.ChatTypeLocal {
+		return simplexCapsNotes
+	}
 	if portal.RoomType == database.RoomTypeDM {
-		return simplexCapsDM
+		return s.Main.capsDM
+	}
+	return s.Main.caps
+}
+
+// makeCapabilities returns the room capabilities for the configured reaction
+// fallback. Only supported and mapped reactions are allowed if reactions that
+// SimpleX doesn't support are rejected.
+func (s *SimplexConnector) makeCapabilities() {
+	s.caps, s.capsDM = simplexCaps, simplexCapsDM
+	if s.Config.Reactions.Fallback != ReactionFallbackReject {
+		return
+	}
+	allowed := s.Config.allowedReactions()
+	restrict := func(caps *event.RoomFeatures) *event.RoomFeatures {
+		restricted := *caps
+		restricted.ID += "+restricted_reactions"
+		restricted.AllowedReactions = allowed
+		return &restricted
 	}
-	return simplexCaps
+	s.caps, s.capsDM = restrict(simplexCaps), restrict(simplexCapsDM)
 }
 
 var simplexGeneralCaps = &bridgev2.NetworkGeneralCapabilities{
@@ -109,7 +145,7 @@ func (s *SimplexConnector) GetCapabilities() *bridgev2.NetworkGeneralCapabilitie
 }
 
 func (s *SimplexConnector) GetBridgeInfoVersion() (info, capabilities int) {
-	return 1, 1
+	return 1, 2
 }
 
 // GetDBMetaTypes returns the metadata type instances for bridgev2 database.
@@ -118,7 +154,7 @@ func (s *SimplexConnector) GetDBMetaTypes() database.MetaTypes {
 		Portal:    func() any { return &simplexid.PortalMetadata{} },
 		Ghost:     func() any { return &simplexid.GhostMetadata{} },
 		Message:   func() any { return &simplexid.MessageMetadata{} },
-		Reaction:  nil,
+		Reaction:  func() any { return &simplexid.ReactionMetadata{} },
 		UserLogin: func() any { return &simplexid.UserLoginMetadata{} },
 	}
 }
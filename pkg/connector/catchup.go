@@ -0,0 +1,132 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+// catchUpPageSize is the number of chat items fetched per request when
+// catching up on missed messages.
+const catchUpPageSize = 100
+
+// catchUpChat queues the messages a chat received after the last bridged one,
+// e.g. while the bridge was down, as live events. Chats without a Matrix room
+// are skipped, they're backfilled when the room is created.
+func (s *SimplexClient) catchUpChat(ctx context.Context, portalKey networkid.PortalKey, chatType simplexclient.ChatType, chatID int64) {
+	log := zerolog.Ctx(ctx).With().Str("portal_id", string(portalKey.ID)).Logger()
+	portal, err := s.Main.Bridge.GetExistingPortalByKey(ctx, portalKey)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal to catch up")
+		return
+	} else if portal == nil || portal.MXID == "" {
+		return
+	}
+	lastItemID := s.getLastBridgedItemID(ctx, portal)
+	if lastItemID == 0 {
+		return
+	}
+	queued := 0
+	for {
+		chat, err := s.Client.GetChat(chatType, chatID, simplexclient.ChatPagination{
+			Type:   simplexclient.PaginationAfter,
+			ItemID: lastItemID,
+			Count:  catchUpPageSize,
+		})
+		if err != nil {
+			log.Err(err).Int64("after_item_id", lastItemID).Msg("Failed to fetch missed messages")
+			break
+		} else if chat == nil || len(chat.ChatItems) == 0 {
+			break
+		}
+		for _, item := range chat.ChatItems {
+			if item.Meta.ItemID <= lastItemID {
+				continue
+			}
+			lastItemID = item.Meta.ItemID
+			if item.Meta.ItemDeleted != nil || s.isReactionFallback(ctx, portal, &item) {
+				continue
+			}
+			s.acceptMissedFile(ctx, portal, &item)
+			s.queueChatItem(ctx, simplexclient.AChatItem{ChatInfo: chat.ChatInfo, ChatItem: item})
+			queued++
+		}
+		if len(chat.ChatItems) < catchUpPageSize {
+			break
+		}
+	}
+	if queued > 0 {
+		log.Info().Int("count", queued).Msg("Queued messages missed while disconnected")
+	}
+}
+
+// acceptMissedFile starts downloading the file of a missed message if the
+// file receive policy allows it, as its rcvFileDescrReady event was missed.
+func (s *SimplexClient) acceptMissedFile(ctx context.Context, portal *bridgev2.Portal, item *simplexclient.ChatItem) {
+	file := item.File
+	if file == nil || file.GetStatus().Type != "rcvInvitation" || !s.Main.canDownloadFile(file.FileSize) {
+		return
+	} else if ok, _ := s.Main.getFilePolicy(portal).allows(file.FileName, file.FileSize); !ok {
+		return
+	}
+	if err := s.Client.ReceiveFile(file.FileID); err != nil {
+		zerolog.Ctx(ctx).Err(err).Int64("file_id", file.FileID).Msg("Failed to accept missed file download")
+	}
+}
+
+// getLastBridgedItemID returns the ID of the newest chat item bridged to the
+// portal. Messages are only stored in the database once they've been sent to
+// Matrix, so a message that failed to bridge is caught up on again.
+func (s *SimplexClient) getLastBridgedItemID(ctx context.Context, portal *bridgev2.Portal) int64 {
+	msg, err := s.Main.Bridge.DB.Message.GetLastPartAtOrBeforeTime(ctx, portal.PortalKey, time.Now())
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get last bridged message")
+		return 0
+	} else if msg == nil {
+		return 0
+	}
+	itemID, _ := simplexid.ParseMessageID(msg.ID)
+	return itemID
+}
+
+// isReactionFallback checks whether an item is a "reacted with" reply that
+// the bridge sent for a Matrix reaction. Those aren't stored as messages, so
+// they'd otherwise be caught up on as new messages.
+func (s *SimplexClient) isReactionFallback(ctx context.Context, portal *bridgev2.Portal, item *simplexclient.ChatItem) bool {
+	if item.QuotedItem == nil || item.QuotedItem.ItemID == nil {
+		return false
+	}
+	reactions, err := s.Main.Bridge.DB.Reaction.GetAllToMessage(ctx, portal.Receiver, simplexid.MakeMessageID(*item.QuotedItem.ItemID))
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get reactions to quoted message")
+		return false
+	}
+	for _, reaction := range reactions {
+		if meta, ok := reaction.Metadata.(*simplexid.ReactionMetadata); ok && meta.FallbackItemID == item.Meta.ItemID {
+			return true
+		}
+	}
+	return false
+}
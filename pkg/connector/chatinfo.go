@@ -39,8 +39,11 @@ func (s *SimplexClient) GetChatInfo(ctx context.Context, portal *bridgev2.Portal
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse portal ID: %w", err)
 	}
-	if chatType == simplexclient.ChatTypeGroup {
+	switch chatType {
+	case simplexclient.ChatTypeGroup:
 		return s.getGroupChatInfo(ctx, chatID)
+	case simplexclient.ChatTypeLocal:
+		return s.notesChatInfo(), nil
 	}
 	return s.getDMChatInfo(ctx, chatID)
 }
@@ -68,7 +71,7 @@ func (s *SimplexClient) getDMChatInfo(ctx context.Context, contactID int64) (*br
 	if contact == nil {
 		return nil, fmt.Errorf("contact %d not found", contactID)
 	}
-	return s.contactToChatInfo(contact, loginID), nil
+	return s.contactToChatInfo(contact, loginID, s.getContactIncognitoName(ctx, contact)), nil
 }
 
 func (s *SimplexClient) getGroupChatInfo(ctx context.Context, groupID int64) (*bridgev2.ChatInfo, error) {
@@ -100,7 +103,38 @@ func (s *SimplexClient) getGroupChatInfo(ctx context.Context, groupID int64) (*b
 	return s.groupToChatInfo(group, members, loginID), nil
 }
 
-func (s *SimplexClient) contactToChatInfo(contact *simplexclient.Contact, selfLoginID int64) *bridgev2.ChatInfo {
+// notesChatInfo returns the info of the local notes chat, which is bridged as
+// a room with only the user in it.
+func (s *SimplexClient) notesChatInfo() *bridgev2.ChatInfo {
+	loginID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
+	selfUserID := simplexid.MakeUserID(loginID)
+	name := "SimpleX notes"
+	topic := "Your SimpleX notes to self"
+	return &bridgev2.ChatInfo{
+		Name:  &name,
+		Topic: &topic,
+		Members: &bridgev2.ChatMemberList{
+			IsFull: true,
+			MemberMap: map[networkid.UserID]bridgev2.ChatMember{
+				selfUserID: {
+					EventSender: bridgev2.EventSender{Sender: selfUserID, IsFromMe: true},
+					Membership:  event.MembershipJoin,
+				},
+			},
+		},
+		Type: ptr.Ptr(database.RoomTypeDefault),
+		ExtraUpdates: func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
+			meta := portal.Metadata.(*simplexid.PortalMetadata)
+			if meta.LastSync.IsZero() {
+				meta.LastSync.Time = time.Now()
+				return true
+			}
+			return false
+		},
+	}
+}
+
+func (s *SimplexClient) contactToChatInfo(contact *simplexclient.Contact, selfLoginID int64, incognitoName string) *bridgev2.ChatInfo {
 	name := contact.Profile.DisplayName
 	if name == "" {
 		name = contact.LocalDisplayName
@@ -116,19 +150,24 @@ func (s *SimplexClient) contactToChatInfo(contact *simplexclient.Contact, selfLo
 				UserInfo:    s.contactToUserInfo(contact),
 			},
 			selfUserID: {
-				EventSender: bridgev2.EventSender{Sender: selfUserID, IsFromMe: true},
-				Membership:  event.MembershipJoin,
+				EventSender:      bridgev2.EventSender{Sender: selfUserID, IsFromMe: true},
+				Membership:       event.MembershipJoin,
+				MemberEventExtra: incognitoMemberExtra(incognitoName),
 			},
 		},
 		OtherUserID: otherUserID,
 	}
 	topic := "SimpleX DM"
+	if incognitoName != "" {
+		topic = fmt.Sprintf("SimpleX DM (incognito as %s)", incognitoName)
+	}
 	return &bridgev2.ChatInfo{
 		Name:    &name,
 		Topic:   &topic,
 		Members: members,
 		Type:    ptr.Ptr(database.RoomTypeDM),
 		ExtraUpdates: func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
+			s.syncIncognitoDisplayname(ctx, portal, incognitoName)
 			meta := portal.Metadata.(*simplexid.PortalMetadata)
 			if meta.LastSync.IsZero() {
 				meta.LastSync.Time = time.Now()
@@ -148,6 +187,10 @@ func (s *SimplexClient) groupToChatInfo(group *simplexclient.GroupInfo, members
 	if group.GroupProfile.Description != nil {
 		topic = *group.GroupProfile.Description
 	}
+	var incognitoName string
+	if group.Membership.Incognito() {
+		incognitoName = group.Membership.Profile.DisplayName
+	}
 
 	memberMap := make(map[networkid.UserID]bridgev2.ChatMember, len(members)+1)
 	for i, m := range members {
@@ -164,33 +207,67 @@ func (s *SimplexClient) groupToChatInfo(group *simplexclient.GroupInfo, members
 		if m.MemberRole == simplexclient.GroupMemberRoleAdmin || m.MemberRole == simplexclient.GroupMemberRoleOwner {
 			pl = 50
 		}
-		memberMap[userID] = bridgev2.ChatMember{
+		member := bridgev2.ChatMember{
 			EventSender: bridgev2.EventSender{Sender: userID},
 			Membership:  event.MembershipJoin,
 			PowerLevel:  &pl,
 			UserInfo:    s.memberToUserInfo(&members[i]),
 		}
+		if bc := group.BusinessChat; bc != nil {
+			// Staff of the business can manage the chat, the customer can't.
+			role := businessRoleStaff
+			if m.MemberID == bc.CustomerID {
+				role, pl = businessRoleCustomer, 0
+			} else {
+				pl = max(pl, 50)
+			}
+			member.MemberEventExtra = map[string]any{businessRoleKey: role}
+		}
+		memberMap[userID] = member
 	}
 	// Add the local (self) user so the bridge invites @testuser to the room.
 	selfUserID := simplexid.MakeUserID(selfLoginID)
 	selfPL := 50
-	memberMap[selfUserID] = bridgev2.ChatMember{
-		EventSender: bridgev2.EventSender{Sender: selfUserID, IsFromMe: true},
-		Membership:  event.MembershipJoin,
-		PowerLevel:  &selfPL,
+	self := bridgev2.ChatMember{
+		EventSender:      bridgev2.EventSender{Sender: selfUserID, IsFromMe: true},
+		Membership:       event.MembershipJoin,
+		PowerLevel:       &selfPL,
+		MemberEventExtra: incognitoMemberExtra(incognitoName),
+	}
+	if bc := group.BusinessChat; bc != nil {
+		role := businessRoleStaff
+		if bc.ChatType == "business" {
+			role = businessRoleCustomer
+		}
+		if self.MemberEventExtra == nil {
+			self.MemberEventExtra = map[string]any{}
+		}
+		self.MemberEventExtra[businessRoleKey] = role
 	}
+	memberMap[selfUserID] = self
 
 	chatMembers := &bridgev2.ChatMemberList{
 		IsFull:    true,
 		MemberMap: memberMap,
 	}
 
+	if bc := group.BusinessChat; bc != nil && topic == "" {
+		topic = businessChatTopic(bc, members)
+	}
+	if incognitoName != "" {
+		if topic != "" {
+			topic += "\n\n"
+		}
+		topic += fmt.Sprintf("You're incognito in this group as %s", incognitoName)
+	}
+
 	ci := &bridgev2.ChatInfo{
 		Name:    &name,
 		Topic:   &topic,
 		Members: chatMembers,
 		Type:    ptr.Ptr(database.RoomTypeDefault),
 		ExtraUpdates: func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
+			s.syncIncognitoDisplayname(ctx, portal, incognitoName)
 			meta := portal.Metadata.(*simplexid.PortalMetadata)
 			if meta.LastSync.IsZero() {
 				meta.LastSync.Time = time.Now()
@@ -212,6 +289,31 @@ func (s *SimplexClient) groupToChatInfo(group *simplexclient.GroupInfo, members
 	return ci
 }
 
+const (
+	// businessRoleKey is the member event field that says whether a member of
+	// a business chat is the customer or a staff member of the business.
+	businessRoleKey      = "fi.mau.simplex.business_role"
+	businessRoleCustomer = "customer"
+	businessRoleStaff    = "staff"
+)
+
+// businessChatTopic returns the room topic of a business chat.
+func businessChatTopic(bc *simplexclient.BusinessChatInfo, members []simplexclient.GroupMember) string {
+	if bc.ChatType == "business" {
+		return "SimpleX business chat"
+	}
+	for i := range members {
+		if members[i].MemberID == bc.CustomerID {
+			name := members[i].Profile.DisplayName
+			if name == "" {
+				name = members[i].LocalDisplayName
+			}
+			return "SimpleX business chat with customer " + name
+		}
+	}
+	return "SimpleX business chat with a customer"
+}
+
 // GetUserInfo implements bridgev2.NetworkAPI.
 func (s *SimplexClient) GetUserInfo(ctx context.Context, ghost *bridgev2.Ghost) (*bridgev2.UserInfo, error) {
 	contactID, err := simplexid.ParseUserID(ghost.ID)
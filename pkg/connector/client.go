@@ -19,6 +19,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -39,10 +40,25 @@ type SimplexClient struct {
 	wsURL    string
 	stopCh   chan struct{}
 	cancelFn context.CancelFunc
+
+	// process is the supervised simplex-chat process (managed mode only).
+	process *simplexProcess
+
+	// fileProgress tracks the last bridged progress step of file transfers.
+	fileProgress     map[int64]int
+	fileProgressLock sync.Mutex
+
+	// liveMessages tracks the throttled edits of incoming live messages.
+	liveMessages     map[int64]*liveMessage
+	liveMessagesLock sync.Mutex
 }
 
 var _ bridgev2.NetworkAPI = (*SimplexClient)(nil)
 
+// managedStartTimeout is how long to wait for a managed simplex-chat process
+// to start accepting WebSocket connections.
+const managedStartTimeout = 60 * time.Second
+
 func (s *SimplexClient) Connect(ctx context.Context) {
 	meta := s.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
 	if s.wsURL == "" && meta.WSUrl == "" {
@@ -55,9 +71,88 @@ func (s *SimplexClient) Connect(ctx context.Context) {
 	if s.wsURL == "" {
 		s.wsURL = meta.WSUrl
 	}
+	if meta.Managed && !s.tryStartManagedProcess(ctx) {
+		return
+	}
 	s.tryConnect(ctx, 0)
 }
 
+// retryDelay returns how long to wait before the given retry of connecting
+// or starting the managed process.
+func retryDelay(retryCount int) time.Duration {
+	retryIn := 2 << retryCount
+	if retryIn > 150 {
+		retryIn = 150
+	}
+	return time.Duration(retryIn) * time.Second
+}
+
+// tryStartManagedProcess starts the managed simplex-chat process, retrying
+// with backoff until it succeeds. It returns false if the context is
+// cancelled before the process is ready.
+func (s *SimplexClient) tryStartManagedProcess(ctx context.Context) bool {
+	log := zerolog.Ctx(ctx)
+	for retryCount := 0; ; retryCount++ {
+		err := s.startManagedProcess(ctx)
+		if err == nil {
+			return true
+		}
+		log.Err(err).Msg("Failed to start managed simplex-chat process")
+		s.UserLogin.BridgeState.Send(status.BridgeState{
+			StateEvent: status.StateTransientDisconnect,
+			Error:      "simplex-process-start-error",
+			Message:    err.Error(),
+		})
+		retryIn := retryDelay(retryCount)
+		log.Debug().Stringer("retry_in", retryIn).Msg("Retrying managed process start")
+		select {
+		case <-time.After(retryIn):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// startManagedProcess spawns the supervised simplex-chat process from the
+// stored database path if it isn't already running, and waits for its
+// WebSocket to accept connections.
+func (s *SimplexClient) startManagedProcess(ctx context.Context) error {
+	meta := s.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
+	if meta.DBPath == "" {
+		return fmt.Errorf("no database path stored for managed login")
+	}
+	if s.process == nil {
+		port := portFromWSURL(meta.WSUrl)
+		if port == 0 || !isPortFree(port) {
+			var err error
+			port, err = findFreePort()
+			if err != nil {
+				return fmt.Errorf("failed to find free port: %w", err)
+			}
+		}
+		dbKey, err := s.Main.getDBKey(meta)
+		if err != nil {
+			return err
+		}
+		s.process = s.Main.newSimplexProcess(meta.DBPath, dbKey, port, *zerolog.Ctx(ctx))
+		if wsURL := s.process.WSURL(); wsURL != meta.WSUrl {
+			meta.WSUrl = wsURL
+			if err := s.UserLogin.Save(ctx); err != nil {
+				zerolog.Ctx(ctx).Err(err).Msg("Failed to save new WebSocket URL")
+			}
+		}
+	}
+	s.wsURL = s.process.WSURL()
+	if err := s.process.Start(); err != nil {
+		return err
+	}
+	if err := s.process.WaitReady(ctx, managedStartTimeout); err != nil {
+		s.process.Stop()
+		return err
+	}
+	return nil
+}
+
 func (s *SimplexClient) tryConnect(ctx context.Context, retryCount int) {
 	if retryCount == 0 {
 		s.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnecting})
@@ -72,13 +167,10 @@ func (s *SimplexClient) tryConnect(ctx context.Context, retryCount int) {
 			Error:      "websocket-connect-error",
 			Message:    err.Error(),
 		})
-		retryIn := 2 << retryCount
-		if retryIn > 150 {
-			retryIn = 150
-		}
-		log.Debug().Int("retry_in_seconds", retryIn).Msg("Retrying connection")
+		retryIn := retryDelay(retryCount)
+		log.Debug().Stringer("retry_in", retryIn).Msg("Retrying connection")
 		select {
-		case <-time.After(time.Duration(retryIn) * time.Second):
+		case <-time.After(retryIn):
 		case <-ctx.Done():
 			return
 		}
@@ -133,6 +225,9 @@ func (s *SimplexClient) Disconnect() {
 		}
 		s.Client = nil
 	}
+	if s.process != nil {
+		s.process.Stop()
+	}
 }
 
 func (s *SimplexClient) IsLoggedIn() bool {
@@ -161,6 +256,8 @@ func (s *SimplexClient) makePortalKeyFromChatInfo(chatInfo simplexclient.ChatInf
 		portalID = simplexid.MakeDMPortalID(chatInfo.Contact.ContactID)
 	} else if chatInfo.Type == "group" && chatInfo.GroupInfo != nil {
 		portalID = simplexid.MakeGroupPortalID(chatInfo.GroupInfo.GroupID)
+	} else if chatInfo.Type == "local" && chatInfo.NoteFolder != nil {
+		portalID = simplexid.MakeNotesPortalID(chatInfo.NoteFolder.NoteFolderID)
 	} else {
 		portalID = networkid.PortalID(fmt.Sprintf("unknown:%s", chatInfo.Type))
 	}
@@ -173,8 +270,8 @@ func (s *SimplexClient) makePortalKeyFromChatInfo(chatInfo simplexclient.ChatInf
 // makeEventSender creates an EventSender for a chat item direction.
 func (s *SimplexClient) makeEventSenderFromDir(dir simplexclient.ChatItemDir) bridgev2.EventSender {
 	switch dir.Type {
-	case "directSnd", "groupSnd":
-		// Sent by us
+	case "directSnd", "groupSnd", "localSnd", "localRcv":
+		// Sent by us, notes are always our own
 		loginID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
 		return bridgev2.EventSender{
 			IsFromMe: true,
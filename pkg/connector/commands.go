@@ -0,0 +1,756 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+var HelpSectionSimplex = commands.HelpSection{Name: "SimpleX", Order: 15}
+
+// registerCommands adds the SimpleX-specific bot commands to the bridge.
+func (s *SimplexConnector) registerCommands() {
+	proc, ok := s.Bridge.Commands.(*commands.Processor)
+	if !ok {
+		return
+	}
+	proc.AddHandlers(
+		cmdSetDBKey,
+		cmdExportDB,
+		cmdDownload,
+		cmdFilePolicy,
+		cmdDeleteMode,
+		cmdForward,
+		cmdEditHistory,
+		cmdBusinessAddress,
+		cmdGroupLink,
+		cmdJoinGroup,
+		cmdConnect,
+	)
+}
+
+// getClientForCommand returns the SimpleX client of the user's default login,
+// replying with an error if there is none.
+func getClientForCommand(ce *commands.Event) *SimplexClient {
+	login := ce.User.GetDefaultLogin()
+	if login == nil {
+		ce.Reply("You're not logged in")
+		return nil
+	}
+	sc, ok := login.Client.(*SimplexClient)
+	if !ok || sc.Client == nil {
+		ce.Reply("You're not connected to SimpleX")
+		return nil
+	}
+	return sc
+}
+
+// getPortalLogin returns the login that owns the command's portal, as chat
+// and message IDs in the portal are only valid for that SimpleX account. It
+// replies with an error if the portal doesn't belong to the caller.
+func getPortalLogin(ce *commands.Event) *bridgev2.UserLogin {
+	login, err := ce.Bridge.GetExistingUserLoginByID(ce.Ctx, ce.Portal.Receiver)
+	if err != nil {
+		ce.Reply("Failed to get login of this room: %v", err)
+		return nil
+	} else if login == nil || login.UserMXID != ce.User.MXID {
+		ce.Reply("This room doesn't belong to your SimpleX account")
+		return nil
+	}
+	return login
+}
+
+// getClientForPortalCommand returns the connected client of the login that
+// owns the command's portal.
+func getClientForPortalCommand(ce *commands.Event) *SimplexClient {
+	login := getPortalLogin(ce)
+	if login == nil {
+		return nil
+	}
+	sc, ok := login.Client.(*SimplexClient)
+	if !ok || sc.Client == nil {
+		ce.Reply("You're not connected to SimpleX")
+		return nil
+	}
+	return sc
+}
+
+var cmdSetDBKey = &commands.FullHandler{
+	Func: fnSetDBKey,
+	Name: "set-db-key",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Set or change the passphrase of the managed SimpleX database.",
+		Args:        "<_new passphrase_>",
+	},
+	RequiresLogin: true,
+}
+
+func fnSetDBKey(ce *commands.Event) {
+	// Don't leave the passphrase in the room history.
+	ce.Redact()
+	if len(ce.Args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix set-db-key <new passphrase>`")
+		return
+	}
+	sc := getClientForCommand(ce)
+	if sc == nil {
+		return
+	}
+	meta := sc.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
+	if !meta.Managed || sc.process == nil {
+		ce.Reply("The database passphrase can only be changed in managed mode")
+		return
+	}
+	newKey := ce.RawArgs
+	currentKey, err := sc.Main.getDBKey(meta)
+	if err != nil {
+		ce.Reply("Failed to get current passphrase: %v", err)
+		return
+	}
+	encryptedKey, err := sc.Main.encryptDBKey(newKey)
+	if err != nil {
+		ce.Reply("Failed to encrypt new passphrase: %v", err)
+		return
+	}
+
+	if err = sc.Client.StopChat(); err != nil {
+		ce.Reply("Failed to stop chat: %v", err)
+		return
+	}
+	encErr := sc.Client.SetDBEncryption(currentKey, newKey)
+	if err = sc.Client.StartChat(); err != nil {
+		ce.Log.Err(err).Msg("Failed to restart chat after changing database passphrase")
+	}
+	if encErr != nil {
+		ce.Reply("Failed to change database passphrase: %v", encErr)
+		return
+	}
+
+	meta.EncryptedDBKey = encryptedKey
+	sc.process.SetDBKey(newKey)
+	if err = sc.UserLogin.Save(ce.Ctx); err != nil {
+		ce.Reply("Passphrase changed, but failed to save it: %v", err)
+		return
+	}
+	ce.Reply("Database passphrase changed")
+}
+
+var cmdExportDB = &commands.FullHandler{
+	Func: fnExportDB,
+	Name: "export-db",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Export the managed SimpleX database as a chat archive that can be imported into the SimpleX app.",
+	},
+	RequiresLogin: true,
+}
+
+func fnExportDB(ce *commands.Event) {
+	sc := getClientForCommand(ce)
+	if sc == nil {
+		return
+	}
+	meta := sc.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
+	if !meta.Managed {
+		ce.Reply("The database can only be exported in managed mode")
+		return
+	}
+	// The archive contains the identity keys and the whole chat history, so
+	// it must never be stored on the homeserver in plaintext.
+	if encrypted, err := isRoomEncrypted(ce.Ctx, ce.Bridge, ce.OrigRoomID); err != nil {
+		ce.Reply("Failed to check if this room is encrypted: %v", err)
+		return
+	} else if !encrypted {
+		ce.Reply("The exported archive contains your SimpleX identity keys and chat history. " +
+			"Enable encryption in this room before exporting it.")
+		return
+	}
+	fileName := fmt.Sprintf("simplex-chat.%s.zip", time.Now().UTC().Format("2006-01-02T150405Z"))
+	archivePath := filepath.Join(getTempFolder(sc.Main.getFilesFolder()), exportFilePrefix+fileName)
+	defer os.Remove(archivePath)
+
+	if err := sc.Client.StopChat(); err != nil {
+		ce.Reply("Failed to stop chat: %v", err)
+		return
+	}
+	archiveErrors, exportErr := sc.Client.ExportArchive(archivePath)
+	if err := sc.Client.StartChat(); err != nil {
+		ce.Log.Err(err).Msg("Failed to restart chat after exporting database")
+	}
+	if exportErr != nil {
+		ce.Reply("Failed to export database: %v", exportErr)
+		return
+	}
+	if len(archiveErrors) > 0 {
+		ce.Log.Warn().Strs("archive_errors", archiveErrors).Msg("Database exported with errors")
+	}
+
+	stat, err := os.Stat(archivePath)
+	if err != nil {
+		ce.Reply("Failed to read exported archive: %v", err)
+		return
+	}
+	uri, file, err := ce.Bot.UploadMediaStream(ce.Ctx, ce.OrigRoomID, stat.Size(), false, func(w io.Writer) (*bridgev2.FileStreamResult, error) {
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err = io.Copy(w, f); err != nil {
+			return nil, err
+		}
+		return &bridgev2.FileStreamResult{FileName: fileName, MimeType: "application/zip"}, nil
+	})
+	if err != nil {
+		ce.Reply("Failed to upload exported archive: %v", err)
+		return
+	}
+	content := &event.MessageEventContent{
+		MsgType: event.MsgFile,
+		Body:    fileName,
+		URL:     uri,
+		File:    file,
+		Info: &event.FileInfo{
+			MimeType: "application/zip",
+			Size:     int(stat.Size()),
+		},
+	}
+	_, err = ce.Bot.SendMessage(ce.Ctx, ce.OrigRoomID, event.EventMessage, &event.Content{Parsed: content}, nil)
+	if err != nil {
+		ce.Reply("Failed to send exported archive: %v", err)
+		return
+	}
+	if len(archiveErrors) > 0 {
+		ce.Reply("Database exported, but %d files could not be included", len(archiveErrors))
+	}
+	if dbKey, err := sc.Main.getDBKey(meta); err == nil && dbKey == "" {
+		ce.Reply("**Warning:** the database isn't encrypted, so anyone who gets the archive can use your SimpleX identity. " +
+			"Use `set-db-key` to set a passphrase, and delete the archive from this room once you've saved it.")
+	}
+}
+
+// isRoomEncrypted checks whether the given room has encryption enabled.
+func isRoomEncrypted(ctx context.Context, br *bridgev2.Bridge, roomID id.RoomID) (bool, error) {
+	mc, ok := br.Matrix.(*matrix.Connector)
+	if !ok {
+		return false, nil
+	}
+	return mc.StateStore.IsEncrypted(ctx, roomID)
+}
+
+var cmdDownload = &commands.FullHandler{
+	Func: fnDownload,
+	Name: "download",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Download a file that wasn't downloaded automatically. Reply to the file notice with this command.",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnDownload(ce *commands.Event) {
+	if ce.ReplyTo == "" {
+		ce.Reply("Reply to a file notice to download the file")
+		return
+	}
+	sc := getClientForPortalCommand(ce)
+	if sc == nil {
+		return
+	}
+	msg, err := ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, ce.ReplyTo)
+	if err != nil {
+		ce.Reply("Failed to get message: %v", err)
+		return
+	} else if msg == nil || msg.Room != ce.Portal.PortalKey {
+		ce.Reply("Message not found")
+		return
+	}
+	if err = sc.downloadPendingFile(ce.Ctx, msg); err != nil {
+		ce.Reply("%v", err)
+		return
+	}
+	ce.React("✅")
+}
+
+var cmdFilePolicy = &commands.FullHandler{
+	Func: fnFilePolicy,
+	Name: "file-policy",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "View or change which incoming files are downloaded automatically in this room.",
+		Args:        "[auto <_on|off_> | max-size <_size_> | types <_types..._|any> | reset]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnFilePolicy(ce *commands.Event) {
+	if getPortalLogin(ce) == nil {
+		return
+	}
+	meta := ce.Portal.Metadata.(*simplexid.PortalMetadata)
+	if len(ce.Args) == 0 {
+		ce.Reply("File policy for this room:\n\n%s", ce.Bridge.Network.(*SimplexConnector).getFilePolicy(ce.Portal))
+		return
+	}
+	if meta.FilePolicy == nil {
+		meta.FilePolicy = &simplexid.FilePolicyOverride{}
+	}
+	switch strings.ToLower(ce.Args[0]) {
+	case "auto":
+		if len(ce.Args) < 2 {
+			ce.Reply("**Usage:** `$cmdprefix file-policy auto <on|off>`")
+			return
+		}
+		var autoAccept bool
+		switch strings.ToLower(ce.Args[1]) {
+		case "on", "true", "yes":
+			autoAccept = true
+		case "off", "false", "no":
+			autoAccept = false
+		default:
+			ce.Reply("**Usage:** `$cmdprefix file-policy auto <on|off>`")
+			return
+		}
+		meta.FilePolicy.AutoAccept = &autoAccept
+	case "max-size":
+		if len(ce.Args) < 2 {
+			ce.Reply("**Usage:** `$cmdprefix file-policy max-size <size>`, e.g. `10MB`")
+			return
+		}
+		size, err := parseFileSize(ce.Args[1])
+		if err != nil {
+			ce.Reply("Invalid size %q", ce.Args[1])
+			return
+		}
+		meta.FilePolicy.MaxSize = &size
+	case "types":
+		if len(ce.Args) < 2 {
+			ce.Reply("**Usage:** `$cmdprefix file-policy types <types...>`, e.g. `image/* .pdf`, or `any`")
+			return
+		}
+		if len(ce.Args) == 2 && strings.EqualFold(ce.Args[1], "any") {
+			meta.FilePolicy.AllowedTypes = []string{"*/*"}
+		} else {
+			meta.FilePolicy.AllowedTypes = ce.Args[1:]
+		}
+	case "reset":
+		meta.FilePolicy = nil
+	default:
+		ce.Reply("**Usage:** `$cmdprefix file-policy [auto <on|off> | max-size <size> | types <types...>|any | reset]`")
+		return
+	}
+	if err := ce.Portal.Save(ce.Ctx); err != nil {
+		ce.Reply("Failed to save file policy: %v", err)
+		return
+	}
+	ce.Reply("File policy for this room updated:\n\n%s", ce.Bridge.Network.(*SimplexConnector).getFilePolicy(ce.Portal))
+}
+
+var cmdDeleteMode = &commands.FullHandler{
+	Func: fnDeleteMode,
+	Name: "delete-mode",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "View or change whether redactions in this room delete messages for everyone or only for you.",
+		Args:        "[_everyone|me_]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnDeleteMode(ce *commands.Event) {
+	if getPortalLogin(ce) == nil {
+		return
+	}
+	meta := ce.Portal.Metadata.(*simplexid.PortalMetadata)
+	if len(ce.Args) == 0 {
+		if meta.DeleteForMe {
+			ce.Reply("Redactions in this room delete messages only for you")
+		} else {
+			ce.Reply("Redactions in this room delete messages for everyone")
+		}
+		return
+	}
+	switch strings.ToLower(ce.Args[0]) {
+	case "everyone":
+		meta.DeleteForMe = false
+	case "me":
+		meta.DeleteForMe = true
+	default:
+		ce.Reply("**Usage:** `$cmdprefix delete-mode [everyone|me]`")
+		return
+	}
+	if err := ce.Portal.Save(ce.Ctx); err != nil {
+		ce.Reply("Failed to save delete mode: %v", err)
+		return
+	}
+	ce.React("✅")
+}
+
+var cmdForward = &commands.FullHandler{
+	Func: fnForward,
+	Name: "forward",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Forward a message to another SimpleX chat. Reply to the message with this command.",
+		Args:        "<_room ID_>",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnForward(ce *commands.Event) {
+	if len(ce.Args) == 0 || ce.ReplyTo == "" {
+		ce.Reply("**Usage:** reply to a message with `$cmdprefix forward <room ID>`")
+		return
+	}
+	// The message is forwarded by the login that owns it, which must also
+	// own the target room.
+	sc := getClientForPortalCommand(ce)
+	if sc == nil {
+		return
+	}
+	msg, err := ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, ce.ReplyTo)
+	if err != nil {
+		ce.Reply("Failed to get message: %v", err)
+		return
+	} else if msg == nil || msg.Room != ce.Portal.PortalKey {
+		ce.Reply("Message not found")
+		return
+	}
+	target, err := ce.Bridge.GetPortalByMXID(ce.Ctx, id.RoomID(ce.Args[0]))
+	if err != nil {
+		ce.Reply("Failed to get target room: %v", err)
+		return
+	} else if target == nil || (target.Receiver != "" && target.Receiver != sc.UserLogin.ID) {
+		ce.Reply("%s is not a SimpleX chat", ce.Args[0])
+		return
+	}
+	if err = sc.forwardMessage(ce.Ctx, msg, target); err != nil {
+		ce.Reply("%v", err)
+		return
+	}
+	ce.React("✅")
+}
+
+var cmdEditHistory = &commands.FullHandler{
+	Func: fnEditHistory,
+	Name: "edit-history",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Show the previous versions of an edited message. Reply to the message with this command.",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnEditHistory(ce *commands.Event) {
+	if ce.ReplyTo == "" {
+		ce.Reply("**Usage:** reply to a message with `$cmdprefix edit-history`")
+		return
+	}
+	sc := getClientForPortalCommand(ce)
+	if sc == nil {
+		return
+	}
+	msg, err := ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, ce.ReplyTo)
+	if err != nil {
+		ce.Reply("Failed to get message: %v", err)
+		return
+	} else if msg == nil || msg.Room != ce.Portal.PortalKey {
+		ce.Reply("Message not found")
+		return
+	}
+	chatType, chatID, err := simplexid.ParsePortalID(ce.Portal.ID)
+	if err != nil {
+		ce.Reply("Failed to parse portal ID: %v", err)
+		return
+	}
+	itemID, err := simplexid.ParseMessageID(msg.ID)
+	if err != nil {
+		ce.Reply("Failed to parse message ID: %v", err)
+		return
+	}
+	info, err := sc.Client.GetChatItemInfo(chatType, chatID, itemID)
+	if err != nil {
+		ce.Reply("Failed to get message history: %v", err)
+		return
+	} else if len(info.ItemVersions) <= 1 {
+		ce.Reply("The message hasn't been edited")
+		return
+	}
+	var out strings.Builder
+	out.WriteString("Message history, newest first:\n")
+	for _, version := range info.ItemVersions {
+		ts := parseSimplexTime(version.ItemVersionTs).UTC().Format("2006-01-02 15:04:05 MST")
+		text := version.MsgContent.Text
+		if text == "" {
+			text = "_(no text)_"
+		}
+		fmt.Fprintf(&out, "\n**%s**\n> %s\n", ts, strings.ReplaceAll(text, "\n", "\n> "))
+	}
+	ce.Reply("%s", out.String())
+}
+
+var cmdBusinessAddress = &commands.FullHandler{
+	Func: fnBusinessAddress,
+	Name: "business-address",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Turn business mode of your SimpleX address on or off. Requests to a business address create a business chat where you can add staff members.",
+		Args:        "<_on|off_>",
+	},
+	RequiresLogin: true,
+}
+
+func fnBusinessAddress(ce *commands.Event) {
+	if len(ce.Args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix business-address <on|off>`")
+		return
+	}
+	var business bool
+	switch strings.ToLower(ce.Args[0]) {
+	case "on", "true", "yes":
+		business = true
+	case "off", "false", "no":
+		business = false
+	default:
+		ce.Reply("**Usage:** `$cmdprefix business-address <on|off>`")
+		return
+	}
+	sc := getClientForCommand(ce)
+	if sc == nil {
+		return
+	}
+	userID, err := simplexid.ParseUserLoginID(sc.UserLogin.ID)
+	if err != nil {
+		ce.Reply("Failed to parse user ID: %v", err)
+		return
+	}
+	// Only the business flag is changed, the other settings are kept.
+	settings, err := sc.Client.GetAddressSettings(userID)
+	if err != nil {
+		ce.Reply("Failed to get address settings: %v", err)
+		return
+	}
+	settings.BusinessAddress = business
+	if business && settings.AutoAccept == nil {
+		// Business requests are always accepted automatically.
+		settings.AutoAccept = &simplexclient.AddressAutoAccept{}
+	}
+	if err = sc.Client.SetAddressSettings(userID, *settings); err != nil {
+		ce.Reply("Failed to update address settings: %v", err)
+		return
+	}
+	if business {
+		ce.Reply("Your SimpleX address is now a business address")
+	} else {
+		ce.Reply("Your SimpleX address is no longer a business address")
+	}
+}
+
+var cmdGroupLink = &commands.FullHandler{
+	Func: fnGroupLink,
+	Name: "group-link",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Show, create or delete the link for joining this group. Members who join with a new link get the member role unless another role is given.",
+		Args:        "[create [_member|observer_] | delete]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnGroupLink(ce *commands.Event) {
+	chatType, groupID, err := simplexid.ParsePortalID(ce.Portal.ID)
+	if err != nil || chatType != simplexclient.ChatTypeGroup {
+		ce.Reply("This is not a SimpleX group")
+		return
+	}
+	sc := getClientForPortalCommand(ce)
+	if sc == nil {
+		return
+	}
+	var link string
+	switch {
+	case len(ce.Args) == 0:
+		link, err = sc.Client.GetGroupLink(groupID)
+		if err != nil {
+			ce.Reply("Failed to get group link: %v", err)
+			return
+		}
+	case strings.EqualFold(ce.Args[0], "create"):
+		role := simplexclient.GroupMemberRoleMember
+		if len(ce.Args) > 1 {
+			role = simplexclient.GroupMemberRole(strings.ToLower(ce.Args[1]))
+		}
+		if role != simplexclient.GroupMemberRoleMember && role != simplexclient.GroupMemberRoleObserver {
+			ce.Reply("**Usage:** `$cmdprefix group-link create [member|observer]`")
+			return
+		}
+		link, err = sc.Client.CreateGroupLink(groupID, role)
+		if err != nil {
+			ce.Reply("Failed to create group link: %v", err)
+			return
+		}
+	case strings.EqualFold(ce.Args[0], "delete"):
+		if err = sc.Client.DeleteGroupLink(groupID); err != nil {
+			ce.Reply("Failed to delete group link: %v", err)
+			return
+		}
+		ce.Reply("Group link deleted")
+		return
+	default:
+		ce.Reply("**Usage:** `$cmdprefix group-link [create [member|observer] | delete]`")
+		return
+	}
+	if err = sendLinkQR(ce, link); err != nil {
+		ce.Log.Err(err).Msg("Failed to send group link QR code")
+		ce.Reply("Group link: %s", link)
+	}
+}
+
+// linkQRSize is the size of link QR codes in pixels.
+const linkQRSize = 512
+
+// sendLinkQR sends a SimpleX link to the command room as a QR code image
+// with the link as its caption.
+func sendLinkQR(ce *commands.Event, link string) error {
+	qrData, err := qrcode.Encode(link, qrcode.Low, linkQRSize)
+	if err != nil {
+		return fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	uri, file, err := ce.Bot.UploadMedia(ce.Ctx, ce.RoomID, qrData, "qr.png", "image/png")
+	if err != nil {
+		return fmt.Errorf("failed to upload QR code: %w", err)
+	}
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgImage,
+		FileName: "qr.png",
+		URL:      uri,
+		File:     file,
+		Info: &event.FileInfo{
+			MimeType: "image/png",
+			Size:     len(qrData),
+			Width:    linkQRSize,
+			Height:   linkQRSize,
+		},
+		Body: link,
+	}
+	_, err = ce.Bot.SendMessage(ce.Ctx, ce.RoomID, event.EventMessage, &event.Content{Parsed: content}, nil)
+	return err
+}
+
+var cmdJoinGroup = &commands.FullHandler{
+	Func: fnJoinGroup,
+	Name: "join-group",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Join a SimpleX group with a group link, optionally with a random incognito profile. A room is created once the group host accepts you.",
+		Args:        "[--incognito] <_group link_>",
+	},
+	RequiresLogin: true,
+}
+
+func fnJoinGroup(ce *commands.Event) {
+	incognito, args := parseIncognitoFlag(ce.Args)
+	if len(args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix join-group [--incognito] <group link>`")
+		return
+	}
+	sc := getClientForCommand(ce)
+	if sc == nil {
+		return
+	}
+	userID, err := simplexid.ParseUserLoginID(sc.UserLogin.ID)
+	if err != nil {
+		ce.Reply("Failed to parse user ID: %v", err)
+		return
+	}
+	if err = sc.Client.Connect(userID, args[0], incognito); err != nil {
+		ce.Reply("Failed to join group: %v", err)
+		return
+	}
+	ce.Reply("Joining group, a room will be created once you've joined")
+}
+
+var cmdConnect = &commands.FullHandler{
+	Func: fnConnect,
+	Name: "connect",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionSimplex,
+		Description: "Connect to a SimpleX address or one-time invitation link, optionally with a random incognito profile. A room is created once the contact accepts.",
+		Args:        "[--incognito] <_link_>",
+	},
+	RequiresLogin: true,
+}
+
+func fnConnect(ce *commands.Event) {
+	incognito, args := parseIncognitoFlag(ce.Args)
+	if len(args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix connect [--incognito] <link>`")
+		return
+	}
+	sc := getClientForCommand(ce)
+	if sc == nil {
+		return
+	}
+	userID, err := simplexid.ParseUserLoginID(sc.UserLogin.ID)
+	if err != nil {
+		ce.Reply("Failed to parse user ID: %v", err)
+		return
+	}
+	if err = sc.Client.Connect(userID, args[0], incognito); err != nil {
+		ce.Reply("Failed to connect: %v", err)
+		return
+	}
+	ce.Reply("Connecting, a room will be created once the contact accepts")
+}
+
+// parseIncognitoFlag removes the --incognito flag from command arguments and
+// reports whether it was present.
+func parseIncognitoFlag(args []string) (bool, []string) {
+	var incognito bool
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.EqualFold(arg, "--incognito") {
+			incognito = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return incognito, rest
+}
@@ -18,10 +18,15 @@ package connector
 
 import (
 	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 
 	up "go.mau.fi/util/configupgrade"
+	"go.mau.fi/util/random"
+	"go.mau.fi/util/variationselector"
 	"gopkg.in/yaml.v3"
 )
 
@@ -41,10 +46,91 @@ type SimplexConfig struct {
 	// resolved using Cloudflare for Families DNS (1.1.1.3 / 1.0.0.3).
 	// This filters malware and adult-content domains at the DNS level.
 	LinkPreviewFamilyDNS bool `yaml:"link_preview_family_dns"`
+	// LinkPreviews controls how link previews for messages sent from Matrix
+	// are generated.
+	LinkPreviews LinkPreviewConfig `yaml:"link_previews"`
+	// MaxOutgoingFileSize is the maximum size in bytes of files bridged from
+	// Matrix to SimpleX. Zero means no limit.
+	MaxOutgoingFileSize int64 `yaml:"max_outgoing_file_size"`
+	// MaxIncomingFileSize is the maximum size in bytes of files bridged from
+	// SimpleX to Matrix. Zero means no limit.
+	MaxIncomingFileSize int64 `yaml:"max_incoming_file_size"`
+	// DataDirectory is the base directory for databases created by the
+	// bridge, e.g. when importing a chat archive.
+	DataDirectory string `yaml:"data_directory"`
+	// DatabaseKeyFile is a file containing the passphrase for encrypted
+	// databases in managed mode, used when the login has no stored passphrase.
+	DatabaseKeyFile string `yaml:"database_key_file"`
+	// DatabaseKeySecret is used to encrypt database passphrases stored in
+	// the bridge database.
+	DatabaseKeySecret string `yaml:"database_key_secret"`
+	// VoiceEncoder is the command that converts Matrix voice messages to the
+	// m4a/AAC format used by SimpleX. {input} and {output} are replaced with
+	// the file paths. Empty disables transcoding.
+	VoiceEncoder string `yaml:"voice_encoder"`
+	// FilePolicy controls which incoming files are downloaded automatically.
+	FilePolicy FilePolicyConfig `yaml:"file_policy"`
+	// Reactions controls how Matrix reactions with emoji that SimpleX doesn't
+	// support are bridged.
+	Reactions ReactionConfig `yaml:"reactions"`
+	// AcceptContactsIncognito makes the bridge accept contact requests with a
+	// random incognito profile instead of the user's profile.
+	AcceptContactsIncognito bool `yaml:"accept_contacts_incognito"`
 
 	displaynameTemplate *template.Template `yaml:"-"`
+	reactionMap         map[string]string  `yaml:"-"`
 }
 
+// LinkPreviewConfig controls where link previews for outgoing messages come
+// from. Previews attached by the Matrix client are always used.
+type LinkPreviewConfig struct {
+	// Homeserver enables asking the homeserver's preview_url endpoint.
+	Homeserver bool `yaml:"homeserver"`
+	// DirectFetch enables fetching pages from the bridge host, which exposes
+	// its IP address to the linked sites.
+	DirectFetch bool `yaml:"direct_fetch"`
+	// AllowedDomains limits direct fetching to these domains and their
+	// subdomains. Empty allows all domains.
+	AllowedDomains []string `yaml:"allowed_domains"`
+	// DeniedDomains are never fetched directly.
+	DeniedDomains []string `yaml:"denied_domains"`
+}
+
+// FilePolicyConfig is the bridge-wide policy for downloading incoming files.
+type FilePolicyConfig struct {
+	// AutoAccept controls whether incoming files are downloaded automatically.
+	AutoAccept bool `yaml:"auto_accept"`
+	// AutoAcceptMaxSize is the maximum size in bytes of files that are
+	// downloaded automatically. Zero means max_incoming_file_size applies.
+	AutoAcceptMaxSize int64 `yaml:"auto_accept_max_size"`
+	// AllowedMimeTypes lists MIME types (e.g. image/*) that are downloaded
+	// automatically. If both lists are empty, all types are allowed.
+	AllowedMimeTypes []string `yaml:"allowed_mime_types"`
+	// AllowedExtensions lists file extensions (e.g. .pdf) that are
+	// downloaded automatically.
+	AllowedExtensions []string `yaml:"allowed_extensions"`
+	// BackfillMaxSize is the maximum size in bytes of files in backfilled
+	// history that are downloaded. Zero disables downloads during backfill.
+	BackfillMaxSize int64 `yaml:"backfill_max_size"`
+}
+
+// ReactionConfig controls how reactions that SimpleX doesn't support are
+// bridged. SimpleX only supports 👍👎😀😂😢❤🚀✅.
+type ReactionConfig struct {
+	// Map maps other emoji to one of the supported ones.
+	Map map[string]string `yaml:"map"`
+	// Fallback is what to do with reactions that can't be mapped:
+	// ReactionFallbackReject or ReactionFallbackText.
+	Fallback string `yaml:"fallback"`
+}
+
+const (
+	// ReactionFallbackReject refuses the reaction with an error notice.
+	ReactionFallbackReject = "reject"
+	// ReactionFallbackText sends a "reacted with" reply instead.
+	ReactionFallbackText = "text"
+)
+
 type umSimplexConfig SimplexConfig
 
 func (c *SimplexConfig) UnmarshalYAML(node *yaml.Node) error {
@@ -58,7 +144,25 @@ func (c *SimplexConfig) UnmarshalYAML(node *yaml.Node) error {
 func (c *SimplexConfig) PostProcess() error {
 	var err error
 	c.displaynameTemplate, err = template.New("displayname").Parse(c.DisplaynameTemplate)
-	return err
+	if err != nil {
+		return err
+	}
+	switch c.Reactions.Fallback {
+	case ReactionFallbackReject, ReactionFallbackText:
+	case "":
+		c.Reactions.Fallback = ReactionFallbackReject
+	default:
+		return fmt.Errorf("invalid reactions.fallback %q", c.Reactions.Fallback)
+	}
+	c.reactionMap = make(map[string]string, len(c.Reactions.Map))
+	for from, to := range c.Reactions.Map {
+		supported, ok := normalizeEmojiForSimplex(to)
+		if !ok {
+			return fmt.Errorf("reactions.map: %s is not supported by SimpleX", to)
+		}
+		c.reactionMap[variationselector.Remove(from)] = supported
+	}
+	return nil
 }
 
 // DisplaynameParams contains fields for the displayname template.
@@ -80,11 +184,60 @@ func (c *SimplexConfig) FormatDisplayname(displayName string, contactID int64) s
 	return buf.String()
 }
 
+// getFilesFolder returns the configured files folder, falling back to the
+// simplex-chat default of ~/Downloads.
+func (s *SimplexConnector) getFilesFolder() string {
+	if s.Config.FilesFolder != "" {
+		return s.Config.FilesFolder
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Downloads")
+}
+
+// getDataDirectory returns the base directory for bridge-created databases,
+// creating it if necessary.
+func (s *SimplexConnector) getDataDirectory() string {
+	dir := s.Config.DataDirectory
+	if dir == "" {
+		dir = "simplex-data"
+	}
+	_ = os.MkdirAll(dir, 0700)
+	return dir
+}
+
+// getTempFolder returns the temp folder inside the given files folder. It must
+// be on the same filesystem as the files folder to avoid cross-device renames.
+func getTempFolder(filesFolder string) string {
+	return filepath.Join(filesFolder, "tmp")
+}
+
 func upgradeConfig(helper up.Helper) {
 	helper.Copy(up.Str, "displayname_template")
 	helper.Copy(up.Str, "simplex_binary")
 	helper.Copy(up.Str, "files_folder")
 	helper.Copy(up.Bool, "link_preview_family_dns")
+	helper.Copy(up.Bool, "link_previews", "homeserver")
+	helper.Copy(up.Bool, "link_previews", "direct_fetch")
+	helper.Copy(up.List, "link_previews", "allowed_domains")
+	helper.Copy(up.List, "link_previews", "denied_domains")
+	helper.Copy(up.Int, "max_outgoing_file_size")
+	helper.Copy(up.Int, "max_incoming_file_size")
+	helper.Copy(up.Str, "data_directory")
+	helper.Copy(up.Str, "database_key_file")
+	if secret, ok := helper.Get(up.Str, "database_key_secret"); !ok || secret == "generate" {
+		helper.Set(up.Str, random.String(64), "database_key_secret")
+	} else {
+		helper.Copy(up.Str, "database_key_secret")
+	}
+	helper.Copy(up.Str, "voice_encoder")
+	helper.Copy(up.Bool, "file_policy", "auto_accept")
+	helper.Copy(up.Int, "file_policy", "auto_accept_max_size")
+	helper.Copy(up.List, "file_policy", "allowed_mime_types")
+	helper.Copy(up.List, "file_policy", "allowed_extensions")
+	helper.Copy(up.Map, "reactions", "map")
+	helper.Copy(up.Str, "reactions", "fallback")
+	helper.Copy(up.Int, "file_policy", "backfill_max_size")
+	helper.Copy(up.Bool, "accept_contacts_incognito")
 }
 
 func (s *SimplexConnector) GetConfig() (string, any, up.Upgrader) {
@@ -18,10 +18,13 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"syscall"
 	"time"
 
 	"maunium.net/go/mautrix/bridgev2"
@@ -37,6 +40,7 @@ type SimplexConnector struct {
 	Bridge            *bridgev2.Bridge
 	Config            SimplexConfig
 	linkPreviewClient *http.Client
+	caps, capsDM      *event.RoomFeatures
 }
 
 var _ bridgev2.NetworkConnector = (*SimplexConnector)(nil)
@@ -54,20 +58,44 @@ func (s *SimplexConnector) GetName() bridgev2.BridgeName {
 
 func (s *SimplexConnector) Init(bridge *bridgev2.Bridge) {
 	s.Bridge = bridge
+	s.registerCommands()
 }
 
 func (s *SimplexConnector) Start(ctx context.Context) error {
-	s.linkPreviewClient = makeLinkPreviewClient(s.Config.LinkPreviewFamilyDNS)
+	s.linkPreviewClient = makeLinkPreviewClient(&s.Config.LinkPreviews, s.Config.LinkPreviewFamilyDNS)
+	s.makeCapabilities()
+	s.cleanupTempFiles(ctx)
 	return nil
 }
 
-// makeLinkPreviewClient returns an *http.Client for fetching link previews.
+// makeLinkPreviewClient returns an *http.Client for fetching link previews
+// directly. Redirects to domains that aren't allowed are refused, and
+// connections to non-public addresses are refused after DNS resolution, so
+// neither a page, a redirect nor a thumbnail URL can reach internal services.
 // If familyDNS is true, DNS resolution uses Cloudflare for Families servers
 // (1.1.1.3 / 1.0.0.3 and their IPv6 equivalents) which filter malware and
 // adult-content domains.
-func makeLinkPreviewClient(familyDNS bool) *http.Client {
+func makeLinkPreviewClient(cfg *LinkPreviewConfig, familyDNS bool) *http.Client {
+	client := &http.Client{
+		Timeout: linkPreviewTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			} else if !cfg.isDomainAllowed(req.URL.String()) {
+				return fmt.Errorf("redirect to disallowed domain %s", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: refuseNonPublicAddress,
+	}
+	client.Transport = &http.Transport{
+		DialContext: dialer.DialContext,
+	}
 	if !familyDNS {
-		return http.DefaultClient
+		return client
 	}
 	// Cloudflare for Families nameservers — IPv4 primary/secondary then IPv6.
 	nameservers := []string{
@@ -91,15 +119,28 @@ func makeLinkPreviewClient(familyDNS bool) *http.Client {
 			return nil, lastErr
 		},
 	}
-	dialer := &net.Dialer{
-		Timeout:  10 * time.Second,
-		Resolver: resolver,
-	}
-	return &http.Client{
-		Transport: &http.Transport{
-			DialContext: dialer.DialContext,
-		},
+	dialer.Resolver = resolver
+	return client
+}
+
+// refuseNonPublicAddress is a net.Dialer Control hook that refuses to connect
+// to loopback, private, link-local and other non-public addresses.
+func refuseNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse dial address: %w", err)
+	} else if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("refusing to connect to non-public address %s", addrPort.Addr())
 	}
+	return nil
+}
+
+// sharedAddressSpace is the RFC 6598 range used for carrier-grade NAT.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
 }
 
 func (s *SimplexConnector) LoadUserLogin(ctx context.Context, login *bridgev2.UserLogin) error {
@@ -131,6 +172,11 @@ func (s *SimplexConnector) GetLoginFlows() []bridgev2.LoginFlow {
 			Description: "Provide a SimpleX database path and let the bridge manage the process",
 			ID:          "managed",
 		},
+		{
+			Name:        "Import archive",
+			Description: "Import a chat archive exported from the SimpleX app and let the bridge manage the process",
+			ID:          "archive",
+		},
 	}
 }
 
@@ -140,6 +186,8 @@ func (s *SimplexConnector) CreateLogin(ctx context.Context, user *bridgev2.User,
 		return &WebSocketLogin{User: user, Main: s}, nil
 	case "managed":
 		return &ManagedLogin{User: user, Main: s}, nil
+	case "archive":
+		return &ArchiveLogin{ManagedLogin: ManagedLogin{User: user, Main: s}}, nil
 	default:
 		return nil, fmt.Errorf("invalid login flow ID: %s", flowID)
 	}
@@ -0,0 +1,51 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+)
+
+func TestRefuseNonPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:4700:4700::1111]:443", false},
+		{"127.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"10.0.0.1:80", true},
+		{"172.16.5.4:80", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"100.64.0.1:80", true},
+		{"0.0.0.0:80", true},
+		{"[fd00::1]:80", true},
+		{"[fe80::1]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"[::ffff:10.0.0.1]:80", true},
+	}
+	for _, tt := range tests {
+		err := refuseNonPublicAddress("tcp", tt.address, nil)
+		if tt.wantErr && err == nil {
+			t.Errorf("refuseNonPublicAddress(%q) succeeded, want error", tt.address)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("refuseNonPublicAddress(%q) returned error: %v", tt.address, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+// sqliteHeader is the magic string at the start of every unencrypted SQLite
+// database. SQLCipher-encrypted databases start with random bytes instead.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// isDatabaseEncrypted reports whether the chat database at the given
+// simplex-chat path prefix exists and is encrypted.
+func isDatabaseEncrypted(dbPath string) bool {
+	f, err := os.Open(dbPath + "_chat.db")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	if _, err = io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return !bytes.Equal(header, sqliteHeader)
+}
+
+func (s *SimplexConnector) dbKeyCipher() (cipher.AEAD, error) {
+	if s.Config.DatabaseKeySecret == "" {
+		return nil, fmt.Errorf("database_key_secret is not configured")
+	}
+	key := sha256.Sum256([]byte(s.Config.DatabaseKeySecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptDBKey encrypts a database passphrase for storage in the login metadata.
+func (s *SimplexConnector) encryptDBKey(passphrase string) (string, error) {
+	gcm, err := s.dbKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(passphrase), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptDBKey decrypts a database passphrase stored by encryptDBKey.
+func (s *SimplexConnector) decryptDBKey(encrypted string) (string, error) {
+	gcm, err := s.dbKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode stored database key: %w", err)
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("stored database key is too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt stored database key: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// readDBKeyFile reads the admin-configured database passphrase file, if any.
+func (s *SimplexConnector) readDBKeyFile() (string, error) {
+	if s.Config.DatabaseKeyFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(s.Config.DatabaseKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read database key file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// getDBKey returns the passphrase for a managed login's database. A key stored
+// in the login metadata takes precedence over the configured key file.
+func (s *SimplexConnector) getDBKey(meta *simplexid.UserLoginMetadata) (string, error) {
+	if meta.EncryptedDBKey != "" {
+		return s.decryptDBKey(meta.EncryptedDBKey)
+	}
+	return s.readDBKeyFile()
+}
@@ -0,0 +1,116 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+var _ bridgev2.DeleteChatHandlingNetworkAPI = (*SimplexClient)(nil)
+
+// getRedactionDeleteMode returns how a Matrix redaction is bridged. A reason
+// of "for me" or "for everyone" overrides the portal's delete mode.
+func getRedactionDeleteMode(msg *bridgev2.MatrixMessageRemove) simplexclient.DeleteMode {
+	if chatType, _, _ := simplexid.ParsePortalID(msg.Portal.ID); chatType == simplexclient.ChatTypeLocal {
+		// Notes only exist locally.
+		return simplexclient.DeleteModeInternal
+	}
+	switch strings.ToLower(strings.TrimSpace(msg.Content.Reason)) {
+	case "for me":
+		return simplexclient.DeleteModeInternal
+	case "for everyone":
+		return simplexclient.DeleteModeBroadcast
+	}
+	if msg.Portal.Metadata.(*simplexid.PortalMetadata).DeleteForMe {
+		return simplexclient.DeleteModeInternal
+	}
+	return simplexclient.DeleteModeBroadcast
+}
+
+// HandleMatrixDeleteChat deletes the SimpleX chat when a portal is deleted
+// from Matrix. Deleting for everyone deletes the contact or leaves and deletes
+// the group. Otherwise the chat's messages are cleared and the chat is
+// remembered as deleted, so that it's only recreated when a new message
+// arrives. Notes are always only cleared.
+func (s *SimplexClient) HandleMatrixDeleteChat(ctx context.Context, msg *bridgev2.MatrixDeleteChat) error {
+	if s.Client == nil {
+		return bridgev2.ErrNotLoggedIn
+	}
+	chatType, chatID, err := simplexid.ParsePortalID(msg.Portal.ID)
+	if err != nil {
+		return fmt.Errorf("failed to parse portal ID: %w", err)
+	}
+	if !msg.Content.DeleteForEveryone || chatType == simplexclient.ChatTypeLocal {
+		if err = s.Client.ClearChat(chatType, chatID); err != nil {
+			return fmt.Errorf("failed to clear chat: %w", err)
+		}
+		meta := s.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
+		if !slices.Contains(meta.DeletedChats, msg.Portal.ID) {
+			meta.DeletedChats = append(meta.DeletedChats, msg.Portal.ID)
+			if err = s.UserLogin.Save(ctx); err != nil {
+				return fmt.Errorf("failed to save deleted chat: %w", err)
+			}
+		}
+		return nil
+	}
+	if chatType == simplexclient.ChatTypeGroup {
+		if err = s.leaveGroupUnlessOwner(ctx, chatID); err != nil {
+			return err
+		}
+	}
+	if err = s.Client.DeleteChat(chatType, chatID); err != nil {
+		return fmt.Errorf("failed to delete chat: %w", err)
+	}
+	return nil
+}
+
+// leaveGroupUnlessOwner leaves a group before it's deleted, as only owners
+// can delete groups they're still a member of.
+func (s *SimplexClient) leaveGroupUnlessOwner(ctx context.Context, groupID int64) error {
+	chat, err := s.Client.GetChat(simplexclient.ChatTypeGroup, groupID, simplexclient.ChatPagination{
+		Type:  simplexclient.PaginationLast,
+		Count: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get group: %w", err)
+	}
+	if chat == nil || chat.ChatInfo.GroupInfo == nil {
+		return fmt.Errorf("group %d not found", groupID)
+	}
+	membership := &chat.ChatInfo.GroupInfo.Membership
+	switch {
+	case membership.MemberRole == simplexclient.GroupMemberRoleOwner,
+		membership.MemberStatus == "memLeft",
+		membership.MemberStatus == "memRemoved",
+		membership.MemberStatus == "memGroupDeleted":
+		return nil
+	}
+	zerolog.Ctx(ctx).Debug().Int64("group_id", groupID).Msg("Leaving group before deleting it")
+	if err = s.Client.LeaveGroup(groupID); err != nil {
+		return fmt.Errorf("failed to leave group: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+// downloadReaction is the reaction that downloads a file that wasn't
+// downloaded automatically.
+const downloadReaction = "⬇️"
+
+// filePolicy is the effective file receive policy of a portal.
+type filePolicy struct {
+	AutoAccept bool
+	MaxSize    int64
+	MimeTypes  []string
+	Extensions []string
+}
+
+// getFilePolicy returns the file receive policy for the given portal, which
+// may be nil if the portal doesn't exist yet.
+func (s *SimplexConnector) getFilePolicy(portal *bridgev2.Portal) filePolicy {
+	policy := filePolicy{
+		AutoAccept: s.Config.FilePolicy.AutoAccept,
+		MaxSize:    s.Config.FilePolicy.AutoAcceptMaxSize,
+		MimeTypes:  s.Config.FilePolicy.AllowedMimeTypes,
+		Extensions: s.Config.FilePolicy.AllowedExtensions,
+	}
+	if portal == nil {
+		return policy
+	}
+	override := portal.Metadata.(*simplexid.PortalMetadata).FilePolicy
+	if override == nil {
+		return policy
+	}
+	if override.AutoAccept != nil {
+		policy.AutoAccept = *override.AutoAccept
+	}
+	if override.MaxSize != nil {
+		policy.MaxSize = *override.MaxSize
+	}
+	if override.AllowedTypes != nil {
+		policy.MimeTypes, policy.Extensions = splitAllowedTypes(override.AllowedTypes)
+	}
+	return policy
+}
+
+// splitAllowedTypes splits a mixed list of MIME patterns and extensions.
+func splitAllowedTypes(types []string) (mimeTypes, extensions []string) {
+	mimeTypes, extensions = []string{}, []string{}
+	for _, t := range types {
+		if strings.HasPrefix(t, ".") {
+			extensions = append(extensions, t)
+		} else {
+			mimeTypes = append(mimeTypes, t)
+		}
+	}
+	return
+}
+
+// allows reports whether a file should be downloaded automatically. If not,
+// the returned reason explains why.
+func (p filePolicy) allows(fileName string, size int64) (bool, string) {
+	if !p.AutoAccept {
+		return false, "automatic downloads are disabled"
+	}
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return false, fmt.Sprintf("larger than %s", formatFileSize(p.MaxSize))
+	}
+	if len(p.MimeTypes) == 0 && len(p.Extensions) == 0 {
+		return true, ""
+	}
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, allowed := range p.Extensions {
+		if ext != "" && strings.EqualFold(allowed, ext) {
+			return true, ""
+		}
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(ext), ";")
+	for _, pattern := range p.MimeTypes {
+		if matchMimeType(pattern, mimeType) {
+			return true, ""
+		}
+	}
+	return false, "file type not allowed"
+}
+
+// matchMimeType matches a MIME type against a pattern like image/* or */*.
+func matchMimeType(pattern, mimeType string) bool {
+	if mimeType == "" {
+		return pattern == "*/*"
+	}
+	pattern = strings.ToLower(pattern)
+	mimeType = strings.ToLower(mimeType)
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return prefix == "*" || strings.HasPrefix(mimeType, prefix+"/")
+	}
+	return pattern == mimeType
+}
+
+// String formats the policy for the file-policy command.
+func (p filePolicy) String() string {
+	var sb strings.Builder
+	if p.AutoAccept {
+		sb.WriteString("* Automatic downloads: enabled\n")
+	} else {
+		sb.WriteString("* Automatic downloads: disabled\n")
+	}
+	if p.MaxSize > 0 {
+		fmt.Fprintf(&sb, "* Maximum size: %s\n", formatFileSize(p.MaxSize))
+	} else {
+		sb.WriteString("* Maximum size: no limit\n")
+	}
+	allowed := append(append([]string{}, p.MimeTypes...), p.Extensions...)
+	if len(allowed) == 0 {
+		sb.WriteString("* Allowed types: all")
+	} else {
+		fmt.Fprintf(&sb, "* Allowed types: `%s`", strings.Join(allowed, "`, `"))
+	}
+	return sb.String()
+}
+
+// parseFileSize parses a size like 1048576, 500k or 10MB.
+func parseFileSize(str string) (int64, error) {
+	str = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(str)), "B")
+	multiplier := int64(1)
+	if len(str) > 0 {
+		if idx := strings.IndexByte("KMGT", str[len(str)-1]); idx >= 0 {
+			str = str[:len(str)-1]
+			for i := 0; i <= idx; i++ {
+				multiplier *= 1000
+			}
+		}
+	}
+	size, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size")
+	}
+	return size * multiplier, nil
+}
+
+// canDownloadFile reports whether a file is within the hard incoming size
+// limit, i.e. whether it can be downloaded on demand.
+func (s *SimplexConnector) canDownloadFile(size int64) bool {
+	maxSize := s.Config.MaxIncomingFileSize
+	return maxSize <= 0 || size <= maxSize
+}
+
+// pendingFilePart returns the notice for a file that wasn't downloaded
+// automatically, remembering the file ID so it can be downloaded on demand.
+func pendingFilePart(file *simplexclient.CIFile, caption string) *bridgev2.ConvertedMessagePart {
+	notice := fmt.Sprintf(
+		"[File not downloaded: %s, %s. React with %s or reply with the download command to download it]",
+		file.FileName, formatFileSize(file.FileSize), downloadReaction,
+	)
+	if caption != "" {
+		notice = caption + "\n\n" + notice
+	}
+	return &bridgev2.ConvertedMessagePart{
+		ID:   networkid.PartID(""),
+		Type: event.EventMessage,
+		Content: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    notice,
+		},
+		Extra:      map[string]any{},
+		DBMetadata: &simplexid.MessageMetadata{HasFile: true, PendingFileID: file.FileID},
+	}
+}
+
+// downloadPendingFile starts downloading a file that wasn't downloaded
+// automatically. The message is edited when the download completes.
+func (s *SimplexClient) downloadPendingFile(ctx context.Context, msg *database.Message) error {
+	meta, ok := msg.Metadata.(*simplexid.MessageMetadata)
+	if !ok || meta.PendingFileID == 0 {
+		return fmt.Errorf("message doesn't have a file to download")
+	}
+	zerolog.Ctx(ctx).Info().
+		Int64("file_id", meta.PendingFileID).
+		Str("message_id", string(msg.ID)).
+		Msg("Downloading file on demand")
+	if err := s.Client.ReceiveFile(meta.PendingFileID); err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"testing"
+)
+
+func TestParseFileSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "1048576", want: 1048576},
+		{in: "0", want: 0},
+		{in: "500k", want: 500_000},
+		{in: "500KB", want: 500_000},
+		{in: "10MB", want: 10_000_000},
+		{in: "10m", want: 10_000_000},
+		{in: " 2 GB ", wantErr: true},
+		{in: "2GB", want: 2_000_000_000},
+		{in: "1T", want: 1_000_000_000_000},
+		{in: "100b", want: 100},
+		{in: "", wantErr: true},
+		{in: "B", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "1.5MB", wantErr: true},
+		{in: "-5", wantErr: true},
+		{in: "10KiB", wantErr: true},
+		{in: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFileSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFileSize(%q) = %d, want error", tt.in, got)
+			}
+		} else if err != nil {
+			t.Errorf("parseFileSize(%q) returned error: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("parseFileSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMimeType(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		mimeType string
+		want     bool
+	}{
+		{"image/png", "image/png", true},
+		{"image/png", "image/jpeg", false},
+		{"image/*", "image/jpeg", true},
+		{"image/*", "video/mp4", false},
+		{"image/*", "imagex/png", false},
+		{"IMAGE/*", "image/PNG", true},
+		{"*/*", "application/zip", true},
+		{"*/*", "", true},
+		{"image/*", "", false},
+		{"application/pdf", "application/pdf", true},
+		{"application/*", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+	}
+	for _, tt := range tests {
+		if got := matchMimeType(tt.pattern, tt.mimeType); got != tt.want {
+			t.Errorf("matchMimeType(%q, %q) = %t, want %t", tt.pattern, tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestFilePolicyAllows(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   filePolicy
+		fileName string
+		size     int64
+		want     bool
+	}{
+		{"disabled", filePolicy{AutoAccept: false}, "a.png", 1, false},
+		{"no restrictions", filePolicy{AutoAccept: true}, "a.bin", 1 << 30, true},
+		{"under max size", filePolicy{AutoAccept: true, MaxSize: 100}, "a.png", 100, true},
+		{"over max size", filePolicy{AutoAccept: true, MaxSize: 100}, "a.png", 101, false},
+		{"extension", filePolicy{AutoAccept: true, Extensions: []string{".pdf"}}, "doc.PDF", 1, true},
+		{"other extension", filePolicy{AutoAccept: true, Extensions: []string{".pdf"}}, "doc.txt", 1, false},
+		{"no extension", filePolicy{AutoAccept: true, Extensions: []string{".pdf"}}, "pdf", 1, false},
+		{"mime wildcard", filePolicy{AutoAccept: true, MimeTypes: []string{"image/*"}}, "photo.png", 1, true},
+		{"mime mismatch", filePolicy{AutoAccept: true, MimeTypes: []string{"image/*"}}, "clip.zip", 1, false},
+		{"size checked before type", filePolicy{AutoAccept: true, MaxSize: 10, MimeTypes: []string{"*/*"}}, "a.png", 11, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := tt.policy.allows(tt.fileName, tt.size)
+			if got != tt.want {
+				t.Errorf("allows(%q, %d) = %t (%s), want %t", tt.fileName, tt.size, got, reason, tt.want)
+			}
+			if !got && reason == "" {
+				t.Errorf("allows(%q, %d) returned no reason", tt.fileName, tt.size)
+			}
+		})
+	}
+}
+
+func TestSplitAllowedTypes(t *testing.T) {
+	mimeTypes, extensions := splitAllowedTypes([]string{"image/*", ".pdf", "video/mp4", ".zip"})
+	if len(mimeTypes) != 2 || mimeTypes[0] != "image/*" || mimeTypes[1] != "video/mp4" {
+		t.Errorf("unexpected MIME types %v", mimeTypes)
+	}
+	if len(extensions) != 2 || extensions[0] != ".pdf" || extensions[1] != ".zip" {
+		t.Errorf("unexpected extensions %v", extensions)
+	}
+}
@@ -0,0 +1,148 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// tempFilePrefix is the prefix of temp files the bridge creates for
+	// outgoing media. Leftovers are removed on startup.
+	tempFilePrefix = "simplex-send-"
+	// exportFilePrefix is the prefix of temp files for database exports.
+	exportFilePrefix = "simplex-export-"
+)
+
+var errFileTooLarge = errors.New("file too large")
+
+// formatFileSize formats a byte count for display, e.g. "3.2 MB".
+func formatFileSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
+
+// fileTooLargeStatus returns the error for a Matrix file that exceeds the
+// outgoing size limit. The bridge sends it to the room as a notice.
+func fileTooLargeStatus(fileName string, size, limit int64) error {
+	msg := fmt.Sprintf("%s is too large to send to SimpleX (%s, limit is %s)", fileName, formatFileSize(size), formatFileSize(limit))
+	return bridgev2.WrapErrorInStatus(fmt.Errorf("%w: %s", errFileTooLarge, msg)).
+		WithMessage(msg).
+		WithIsCertain(true).
+		WithErrorReason(event.MessageStatusUnsupported).
+		WithSendNotice(true)
+}
+
+// downloadMatrixMediaToTemp streams Matrix media into a new temp file in the
+// simplex-chat temp folder without buffering it in memory. It returns the file
+// path, the number of bytes written and the sniffed MIME type. If maxSize is
+// positive and the file is larger, errFileTooLarge is returned.
+func (s *SimplexClient) downloadMatrixMediaToTemp(ctx context.Context, content *event.MessageEventContent, fileName string, maxSize int64) (path string, size int64, mimeType string, err error) {
+	tmpFile, err := os.CreateTemp(getTempFolder(s.Main.getFilesFolder()), tempFilePrefix+"*-"+filepath.Base(fileName))
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	path = tmpFile.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+	defer tmpFile.Close()
+
+	err = s.Main.Bridge.Bot.DownloadMediaToFile(ctx, content.URL, content.File, false, func(f *os.File) error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var r io.Reader = f
+		if maxSize > 0 {
+			r = io.LimitReader(f, maxSize+1)
+		}
+		size, err = io.Copy(tmpFile, r)
+		if err != nil {
+			return err
+		}
+		if maxSize > 0 && size > maxSize {
+			return errFileTooLarge
+		}
+		return nil
+	})
+	if errors.Is(err, errFileTooLarge) {
+		return "", size, "", errFileTooLarge
+	} else if err != nil {
+		return "", 0, "", fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
+	}
+	mimeType, err = sniffMimeType(path)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to read temp file: %w", err)
+	}
+	return path, size, mimeType, nil
+}
+
+// sniffMimeType detects the MIME type of a file from its first bytes.
+func sniffMimeType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// cleanupTempFiles removes temp files left behind by a previous run, e.g. if
+// the bridge crashed while sending media.
+func (s *SimplexConnector) cleanupTempFiles(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+	tmpDir := getTempFolder(s.getFilesFolder())
+	for _, prefix := range []string{tempFilePrefix, exportFilePrefix} {
+		matches, err := filepath.Glob(filepath.Join(tmpDir, prefix+"*"))
+		if err != nil {
+			log.Err(err).Msg("Failed to list orphaned temp files")
+			continue
+		}
+		for _, path := range matches {
+			if err = os.Remove(path); err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("Failed to remove orphaned temp file")
+			} else {
+				log.Debug().Str("path", path).Msg("Removed orphaned temp file")
+			}
+		}
+	}
+}
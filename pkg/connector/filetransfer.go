@@ -0,0 +1,241 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/status"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+const (
+	// progressMinFileSize is the minimum size of files whose transfer
+	// progress is bridged. Smaller files finish quickly enough.
+	progressMinFileSize = 5 * 1000 * 1000
+	// progressStep is the percentage between progress updates.
+	progressStep = 10
+)
+
+// fileStatusPart returns the notice for a file that hasn't been downloaded,
+// based on its transfer status.
+func (s *SimplexClient) fileStatusPart(portal *bridgev2.Portal, item *simplexclient.ChatItem) *bridgev2.ConvertedMessagePart {
+	file := item.File
+	caption := fileCaption(item)
+	fileStatus := file.GetStatus()
+	var notice string
+	switch fileStatus.Type {
+	case "rcvCancelled":
+		notice = fmt.Sprintf("[File transfer cancelled by sender: %s, %s]", file.FileName, formatFileSize(file.FileSize))
+	case "rcvError", "rcvAborted", "rcvWarning":
+		notice = fmt.Sprintf("[Failed to receive file: %s, %s]", file.FileName, formatFileSize(file.FileSize))
+	case "rcvAccepted", "rcvTransfer":
+		notice = fmt.Sprintf("Receiving %s, %s…", file.FileName, formatFileSize(file.FileSize))
+		if fileStatus.RcvTotal > 0 {
+			notice += fmt.Sprintf(" %d%%", fileStatus.RcvProgress*100/fileStatus.RcvTotal)
+		}
+	default:
+		if ok, _ := s.Main.getFilePolicy(portal).allows(file.FileName, file.FileSize); !ok {
+			return pendingFilePart(file, caption)
+		}
+		notice = fmt.Sprintf("Receiving %s, %s…", file.FileName, formatFileSize(file.FileSize))
+	}
+	if caption != "" {
+		notice = caption + "\n\n" + notice
+	}
+	return &bridgev2.ConvertedMessagePart{
+		ID:   networkid.PartID(""),
+		Type: event.EventMessage,
+		Content: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    notice,
+		},
+		Extra: map[string]any{},
+	}
+}
+
+// handleRcvFileComplete edits the placeholder of a file to the actual file
+// once it's downloaded. It's queued as an upsert, so the portal's event queue
+// orders it after the placeholder, and the file is bridged as a new message
+// if the placeholder was never bridged.
+func (s *SimplexClient) handleRcvFileComplete(ctx context.Context, data simplexclient.RcvFileCompleteEvent) {
+	if data.ChatItem.ChatItem.File != nil {
+		s.clearFileProgress(data.ChatItem.ChatItem.File.FileID)
+	}
+	edit := s.makeChatItemEditEvent(simplexclient.ChatItemUpdatedEvent{
+		User:     data.User,
+		ChatItem: data.ChatItem,
+	}, 0)
+	evt := s.makeChatItemEvent(data.ChatItem)
+	evt.Type = bridgev2.RemoteEventMessageUpsert
+	evt.HandleExistingFunc = func(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message, data *simplexclient.ChatItem) (bridgev2.UpsertResult, error) {
+		return bridgev2.UpsertResult{SubEvents: []bridgev2.RemoteEvent{edit}}, nil
+	}
+	s.UserLogin.QueueRemoteEvent(evt)
+}
+
+// handleRcvFileFailed replaces the placeholder of a file with an error notice.
+func (s *SimplexClient) handleRcvFileFailed(ctx context.Context, user simplexclient.User, aci simplexclient.AChatItem) {
+	if aci.ChatItem.File != nil {
+		s.clearFileProgress(aci.ChatItem.File.FileID)
+	}
+	s.handleChatItemUpdated(ctx, simplexclient.ChatItemUpdatedEvent{
+		User:     user,
+		ChatItem: aci,
+	})
+}
+
+// handleRcvFileProgress updates the placeholder of a large file as it's
+// being downloaded.
+func (s *SimplexClient) handleRcvFileProgress(ctx context.Context, data simplexclient.RcvFileProgressEvent) {
+	if data.ChatItem == nil || data.ChatItem.ChatItem.File == nil {
+		return
+	}
+	file := data.ChatItem.ChatItem.File
+	if !s.shouldUpdateFileProgress(file.FileID, data.ReceivedSize, data.TotalSize) {
+		return
+	}
+	// Make sure the placeholder shows the progress from the event.
+	file.FileStatus, _ = json.Marshal(&simplexclient.CIFileStatus{
+		Type:        "rcvTransfer",
+		RcvProgress: data.ReceivedSize,
+		RcvTotal:    data.TotalSize,
+	})
+	s.handleChatItemUpdated(ctx, simplexclient.ChatItemUpdatedEvent{
+		User:     data.User,
+		ChatItem: *data.ChatItem,
+	})
+}
+
+// handleSndFileProgress reports the upload progress of a large file sent
+// from Matrix as a pending message status.
+func (s *SimplexClient) handleSndFileProgress(ctx context.Context, data simplexclient.SndFileProgressEvent) {
+	if data.ChatItem == nil || data.ChatItem.ChatItem.File == nil {
+		return
+	}
+	file := data.ChatItem.ChatItem.File
+	if !s.shouldUpdateFileProgress(file.FileID, data.SentSize, data.TotalSize) {
+		return
+	}
+	s.sendFileMessageStatus(ctx, *data.ChatItem, &bridgev2.MessageStatus{
+		Step:    status.MsgStepRemote,
+		Status:  event.MessageStatusPending,
+		Message: fmt.Sprintf("Uploading %s: %d%%", file.FileName, data.SentSize*100/data.TotalSize),
+	})
+}
+
+// handleSndFileComplete marks a file sent from Matrix as delivered once the
+// upload is complete.
+func (s *SimplexClient) handleSndFileComplete(ctx context.Context, data simplexclient.SndFileCompleteEvent) {
+	file := data.ChatItem.ChatItem.File
+	if file == nil || !s.clearFileProgress(file.FileID) {
+		// No progress was reported, so the message is still marked as sent.
+		return
+	}
+	s.sendFileMessageStatus(ctx, data.ChatItem, &bridgev2.MessageStatus{
+		Step:   status.MsgStepRemote,
+		Status: event.MessageStatusSuccess,
+	})
+}
+
+// handleSndFileError marks a file sent from Matrix as failed.
+func (s *SimplexClient) handleSndFileError(ctx context.Context, data simplexclient.SndFileErrorEvent) {
+	zerolog.Ctx(ctx).Warn().Str("error_message", data.ErrorMessage).Msg("Failed to send file")
+	if data.ChatItem == nil {
+		return
+	}
+	if file := data.ChatItem.ChatItem.File; file != nil {
+		s.clearFileProgress(file.FileID)
+	}
+	msg := "Failed to upload file to SimpleX"
+	if data.ErrorMessage != "" {
+		msg += ": " + data.ErrorMessage
+	}
+	s.sendFileMessageStatus(ctx, *data.ChatItem, &bridgev2.MessageStatus{
+		Step:        status.MsgStepRemote,
+		Status:      event.MessageStatusFail,
+		ErrorReason: event.MessageStatusNetworkError,
+		Message:     msg,
+		IsCertain:   true,
+		SendNotice:  true,
+	})
+}
+
+// sendFileMessageStatus sends a message status for the Matrix event of a
+// file sent to SimpleX.
+func (s *SimplexClient) sendFileMessageStatus(ctx context.Context, aci simplexclient.AChatItem, ms *bridgev2.MessageStatus) {
+	log := zerolog.Ctx(ctx)
+	msgID := simplexid.MakeMessageID(aci.ChatItem.Meta.ItemID)
+	msg, err := s.Main.Bridge.DB.Message.GetFirstPartByID(ctx, s.UserLogin.ID, msgID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get file message for status update")
+		return
+	} else if msg == nil {
+		return
+	}
+	portal, err := s.Main.Bridge.GetExistingPortalByKey(ctx, msg.Room)
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal for file status update")
+		return
+	} else if portal == nil || portal.MXID == "" {
+		return
+	}
+	s.Main.Bridge.Matrix.SendMessageStatus(ctx, ms, &bridgev2.MessageStatusEventInfo{
+		RoomID:        portal.MXID,
+		SourceEventID: msg.MXID,
+		EventType:     event.EventMessage,
+	})
+}
+
+// shouldUpdateFileProgress reports whether the progress of a transfer has
+// advanced enough since the last update to be bridged.
+func (s *SimplexClient) shouldUpdateFileProgress(fileID, done, total int64) bool {
+	if total < progressMinFileSize || done >= total {
+		return false
+	}
+	step := int(done * 100 / total / progressStep)
+	s.fileProgressLock.Lock()
+	defer s.fileProgressLock.Unlock()
+	if s.fileProgress == nil {
+		s.fileProgress = make(map[int64]int)
+	}
+	last, ok := s.fileProgress[fileID]
+	if ok && step <= last {
+		return false
+	}
+	s.fileProgress[fileID] = step
+	return true
+}
+
+// clearFileProgress forgets the progress of a finished transfer and reports
+// whether any progress had been bridged.
+func (s *SimplexClient) clearFileProgress(fileID int64) bool {
+	s.fileProgressLock.Lock()
+	defer s.fileProgressLock.Unlock()
+	_, ok := s.fileProgress[fileID]
+	delete(s.fileProgress, fileID)
+	return ok
+}
@@ -0,0 +1,72 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+// addForwardedHeader adds a "Forwarded from" header to a forwarded message.
+func addForwardedHeader(cm *bridgev2.ConvertedMessage, item *simplexclient.ChatItem) {
+	forwarded := item.Meta.ItemForwarded
+	if forwarded == nil || item.Meta.ItemDeleted != nil {
+		return
+	}
+	part := firstMessagePart(cm)
+	if part == nil {
+		return
+	}
+	header := "Forwarded"
+	if forwarded.ChatName != "" {
+		header = "Forwarded from " + forwarded.ChatName
+	}
+	prependToContent(part.Content, fmt.Sprintf("↷ %s\n\n", header), fmt.Sprintf("<p><em>↷ %s</em></p>", escapeHTML(header)))
+}
+
+// forwardMessage forwards a bridged message to the chat of another portal
+// with SimpleX's forward API. The forwarded message is bridged to the target
+// room when simplex-chat echoes it back.
+func (s *SimplexClient) forwardMessage(ctx context.Context, msg *database.Message, target *bridgev2.Portal) error {
+	fromType, fromID, err := simplexid.ParsePortalID(msg.Room.ID)
+	if err != nil {
+		return fmt.Errorf("failed to parse source portal ID: %w", err)
+	}
+	toType, toID, err := simplexid.ParsePortalID(target.ID)
+	if err != nil {
+		return fmt.Errorf("failed to parse target portal ID: %w", err)
+	}
+	itemID, err := simplexid.ParseMessageID(msg.ID)
+	if err != nil {
+		return fmt.Errorf("failed to parse message ID: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Int64("item_id", itemID).
+		Str("target_portal_id", string(target.ID)).
+		Msg("Forwarding message")
+	if _, err = s.Client.ForwardChatItems(toType, toID, fromType, fromID, []int64{itemID}); err != nil {
+		return fmt.Errorf("failed to forward message: %w", err)
+	}
+	return nil
+}
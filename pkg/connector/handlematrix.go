@@ -18,18 +18,13 @@ package connector
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"regexp"
-	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/variationselector"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/networkid"
@@ -87,7 +82,11 @@ func (s *SimplexClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.M
 		MsgContent: content,
 		Mentions:   map[string]int64{},
 	}
-	if msg.ReplyTo != nil {
+	var crossChatQuote string
+	if msg.ReplyTo != nil && (msg.ReplyTo.Room != msg.Portal.PortalKey || chatType == simplexclient.ChatTypeLocal) {
+		// SimpleX can only quote messages in the same chat, and notes can't quote at all.
+		crossChatQuote = s.makeCrossChatQuote(ctx, msg.ReplyTo)
+	} else if msg.ReplyTo != nil {
 		itemID, err := simplexid.ParseMessageID(msg.ReplyTo.ID)
 		if err == nil {
 			composed.QuotedItemID = &itemID
@@ -98,37 +97,32 @@ func (s *SimplexClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.M
 	var tmpPathToClean string
 	switch msg.Content.MsgType {
 	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
-		data, err := s.Main.Bridge.Bot.DownloadMedia(ctx, msg.Content.URL, msg.Content.File)
-		if err != nil {
-			return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
+		maxSize := s.Main.Config.MaxOutgoingFileSize
+		if info := msg.Content.GetInfo(); maxSize > 0 && int64(info.Size) > maxSize {
+			return nil, fileTooLargeStatus(msg.Content.GetFileName(), int64(info.Size), maxSize)
 		}
-		tmpDir := filepath.Join(s.Main.Config.FilesFolder, "tmp")
-		fileName := msg.Content.Body
+		fileName := msg.Content.GetFileName()
 		if fileName == "" {
 			fileName = "file"
 		}
-		tmpFile, err := os.CreateTemp(tmpDir, "simplex-send-*-"+filepath.Base(fileName))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		tmpPath, size, sniffed, err := s.downloadMatrixMediaToTemp(ctx, msg.Content, fileName, maxSize)
+		if errors.Is(err, errFileTooLarge) {
+			return nil, fileTooLargeStatus(fileName, size, maxSize)
+		} else if err != nil {
+			return nil, err
 		}
-		tmpPathToClean = tmpFile.Name()
-		if _, err = tmpFile.Write(data); err != nil {
-			tmpFile.Close()
-			os.Remove(tmpPathToClean)
-			return nil, fmt.Errorf("failed to write temp file: %w", err)
-		}
-		tmpFile.Close()
+		tmpPathToClean = tmpPath
 
 		mimeType := msg.Content.GetInfo().MimeType
 		if mimeType == "" {
-			mimeType = http.DetectContentType(data)
+			mimeType = sniffed
 		}
 		msgType := "file"
 		if isImageMime(mimeType) {
 			msgType = "image"
 		} else if isVideoMime(mimeType) {
 			msgType = "video"
-		} else if isAudioMime(mimeType) {
+		} else if isAudioMime(mimeType) && msg.Content.MSC3245Voice != nil {
 			msgType = "voice"
 		}
 		// FileSource carries the actual file path; MsgContent carries the display type+name.
@@ -138,19 +132,33 @@ func (s *SimplexClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.M
 		caption := msg.Content.GetCaption()
 		switch msgType {
 		case "image":
-			thumb := ffmpegThumbnailBase64(ctx, tmpPathToClean)
+			thumb := imageThumbnailBase64(ctx, tmpPathToClean)
 			composed.MsgContent = simplexclient.MakeMsgContentImage(caption, thumb)
 		case "video":
-			thumb := ffmpegThumbnailBase64(ctx, tmpPathToClean)
+			thumb := videoThumbnailBase64(ctx, tmpPathToClean)
 			duration := 0
 			if info := msg.Content.GetInfo(); info != nil && info.Duration > 0 {
 				duration = int(info.Duration / 1000)
+			} else if meta := probeMedia(ctx, tmpPathToClean); meta != nil {
+				duration = meta.Duration / 1000
 			}
 			composed.MsgContent = simplexclient.MakeMsgContentVideo(caption, thumb, duration)
 		case "voice":
+			if !isSimplexVoiceMime(mimeType) && s.Main.Config.VoiceEncoder != "" {
+				converted, err := s.Main.transcodeVoice(ctx, tmpPathToClean)
+				if err != nil {
+					zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to transcode voice message, sending original file")
+				} else {
+					os.Remove(tmpPathToClean)
+					tmpPathToClean = converted
+					composed.FileSource.FilePath = converted
+				}
+			}
 			duration := 0
-			if info := msg.Content.GetInfo(); info != nil && info.Duration > 0 {
-				duration = int(info.Duration / 1000)
+			if meta := probeMedia(ctx, tmpPathToClean); meta != nil && meta.Duration > 0 {
+				duration = (meta.Duration + 500) / 1000
+			} else if info := msg.Content.GetInfo(); info != nil && info.Duration > 0 {
+				duration = (info.Duration + 500) / 1000
 			}
 			composed.MsgContent = simplexclient.MakeMsgContentVoice(caption, duration)
 		default:
@@ -161,13 +169,13 @@ func (s *SimplexClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.M
 	// For plain text messages containing a URL, fetch a link preview and upgrade
 	// the message to a SimpleX "link" type so recipients see the preview card.
 	if composed.FileSource == nil && composed.MsgContent.Type == "text" {
-		if uri := extractFirstURL(composed.MsgContent.Text); uri != "" {
-			zerolog.Ctx(ctx).Debug().Str("uri", uri).Msg("Fetching link preview for outgoing message")
-			if preview := fetchLinkPreview(ctx, s.Main.linkPreviewClient, uri); preview != nil {
-				composed.MsgContent = simplexclient.MakeMsgContentLink(composed.MsgContent.Text, preview)
-			}
+		if preview := s.getLinkPreview(ctx, msg.Content, composed.MsgContent.Text); preview != nil {
+			composed.MsgContent = simplexclient.MakeMsgContentLink(composed.MsgContent.Text, preview)
 		}
 	}
+	if crossChatQuote != "" {
+		composed.MsgContent.Text = crossChatQuote + composed.MsgContent.Text
+	}
 
 	var sent []simplexclient.AChatItem
 	if composed.FileSource != nil {
@@ -175,7 +183,7 @@ func (s *SimplexClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.M
 		// processing a file transfer, and we want to reconnect and retry automatically.
 		sent, err = s.Client.SendMessagesRetryOnce(ctx, chatType, chatID, []simplexclient.ComposedMessage{composed})
 	} else {
-		sent, err = s.Client.SendMessages(chatType, chatID, []simplexclient.ComposedMessage{composed})
+		sent, err = s.Client.SendMessages(chatType, chatID, []simplexclient.ComposedMessage{composed}, isLiveMessage(msg.Event))
 	}
 	// Clean up the temp file after simplex-chat has processed it (response received).
 	if tmpPathToClean != "" {
@@ -217,7 +225,17 @@ func isImageMime(mime string) bool {
 	return false
 }
 
-// HandleMatrixEdit edits an existing SimpleX message.
+// editNotAllowedError returns the error for edits that SimpleX doesn't allow.
+func editNotAllowedError(reason string) error {
+	return bridgev2.WrapErrorInStatus(errors.New(reason)).
+		WithIsCertain(true).
+		WithErrorAsMessage().
+		WithSendNotice(true).
+		WithErrorReason(event.MessageStatusUnsupported)
+}
+
+// HandleMatrixEdit edits an existing SimpleX message. Edits only change the
+// text or caption, so media and link previews are kept.
 func (s *SimplexClient) HandleMatrixEdit(ctx context.Context, msg *bridgev2.MatrixEdit) error {
 	if s.Client == nil {
 		return bridgev2.ErrNotLoggedIn
@@ -230,32 +248,84 @@ func (s *SimplexClient) HandleMatrixEdit(ctx context.Context, msg *bridgev2.Matr
 	if err != nil {
 		return fmt.Errorf("failed to parse message ID: %w", err)
 	}
-	content := MatrixToSimplexMsgContent(msg.Content)
-	_, err = s.Client.UpdateChatItem(chatType, chatID, itemID, content)
+	// The original is needed to keep its message type, as SimpleX would
+	// otherwise replace e.g. a captioned image with a text message.
+	original, err := s.Client.GetChatItem(chatType, chatID, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to get original message: %w", err)
+	} else if original == nil {
+		return fmt.Errorf("original message %d not found", itemID)
+	}
+	if original.Meta.ItemForwarded != nil {
+		return editNotAllowedError("SimpleX doesn't allow editing forwarded messages")
+	} else if !original.Meta.Editable && chatType != simplexclient.ChatTypeLocal {
+		// Notes can always be edited.
+		return editNotAllowedError("SimpleX only allows editing messages for 24 hours after sending them")
+	}
+	content := MatrixEditToSimplexMsgContent(original.Content.MsgContent, msg.Content)
+	_, err = s.Client.UpdateChatItem(chatType, chatID, itemID, content, isLiveMessage(msg.Event))
 	if err != nil {
 		return bridgev2.WrapErrorInStatus(err).WithSendNotice(true)
 	}
 	return nil
 }
 
-// PreHandleMatrixReaction prepares a reaction before sending.
+// PreHandleMatrixReaction prepares a reaction before sending. Emoji that
+// SimpleX doesn't support are mapped to a supported one if configured, and
+// otherwise rejected unless they're sent as text.
 func (s *SimplexClient) PreHandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (bridgev2.MatrixReactionPreResponse, error) {
 	loginUserID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
-	return bridgev2.MatrixReactionPreResponse{
+	resp := bridgev2.MatrixReactionPreResponse{
 		SenderID: simplexid.MakeUserID(loginUserID),
-		EmojiID:  "",
 		Emoji:    msg.Content.RelatesTo.Key,
-	}, nil
+	}
+	if isDownloadReaction(msg) {
+		resp.EmojiID = networkid.EmojiID(resp.Emoji)
+		return resp, nil
+	}
+	if chatType, _, _ := simplexid.ParsePortalID(msg.Portal.ID); chatType == simplexclient.ChatTypeLocal {
+		return resp, bridgev2.WrapErrorInStatus(errors.New("SimpleX notes don't support reactions")).
+			WithIsCertain(true).
+			WithErrorAsMessage().
+			WithSendNotice(true).
+			WithErrorReason(event.MessageStatusUnsupported)
+	}
+	if emoji, ok := s.Main.Config.mapReaction(resp.Emoji); ok {
+		resp.Emoji = emoji
+	} else if s.Main.Config.Reactions.Fallback != ReactionFallbackText {
+		return resp, unsupportedReactionError(resp.Emoji)
+	}
+	// Each user can react with several emoji, so the emoji identifies the reaction.
+	resp.EmojiID = networkid.EmojiID(resp.Emoji)
+	return resp, nil
+}
+
+// isDownloadReaction reports whether a reaction downloads a file that wasn't
+// downloaded automatically.
+func isDownloadReaction(msg *bridgev2.MatrixReaction) bool {
+	if variationselector.Remove(msg.Content.RelatesTo.Key) != variationselector.Remove(downloadReaction) {
+		return false
+	}
+	meta, ok := msg.TargetMessage.Metadata.(*simplexid.MessageMetadata)
+	return ok && meta.PendingFileID != 0
 }
 
-// HandleMatrixReaction sends a reaction to SimpleX.
+// HandleMatrixReaction sends a reaction to SimpleX. Reactions that SimpleX
+// doesn't support are sent as a "reacted with" reply.
 func (s *SimplexClient) HandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (*database.Reaction, error) {
 	if s.Client == nil {
 		return nil, bridgev2.ErrNotLoggedIn
 	}
-	emoji, ok := normalizeEmojiForSimplex(msg.PreHandleResp.Emoji)
-	if !ok {
-		// SimpleX only supports 8 specific emojis — silently ignore unsupported ones.
+	// Reactions bridged before emoji IDs were used are replaced by new ones.
+	if msg.ReactionToOverride != nil {
+		if err := s.removeSimplexReaction(ctx, msg.Portal, msg.ReactionToOverride); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to remove replaced reaction")
+		}
+	}
+	if isDownloadReaction(msg) {
+		if err := s.downloadPendingFile(ctx, msg.TargetMessage); err != nil {
+			return nil, err
+		}
 		return &database.Reaction{}, nil
 	}
 	chatType, chatID, err := simplexid.ParsePortalID(msg.Portal.ID)
@@ -266,6 +336,10 @@ func (s *SimplexClient) HandleMatrixReaction(ctx context.Context, msg *bridgev2.
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse message ID: %w", err)
 	}
+	emoji, ok := normalizeEmojiForSimplex(msg.PreHandleResp.Emoji)
+	if !ok {
+		return s.sendReactionAsText(ctx, msg.Portal, itemID, msg.PreHandleResp.Emoji)
+	}
 	err = s.Client.ReactToChatItem(chatType, chatID, itemID, emoji, true)
 	if err != nil {
 		return nil, err
@@ -278,18 +352,12 @@ func (s *SimplexClient) HandleMatrixReactionRemove(ctx context.Context, msg *bri
 	if s.Client == nil {
 		return bridgev2.ErrNotLoggedIn
 	}
-	chatType, chatID, err := simplexid.ParsePortalID(msg.Portal.ID)
-	if err != nil {
-		return fmt.Errorf("failed to parse portal ID: %w", err)
-	}
-	itemID, err := simplexid.ParseMessageID(msg.TargetReaction.MessageID)
-	if err != nil {
-		return fmt.Errorf("failed to parse message ID: %w", err)
-	}
-	return s.Client.ReactToChatItem(chatType, chatID, itemID, msg.TargetReaction.Emoji, false)
+	return s.removeSimplexReaction(ctx, msg.Portal, msg.TargetReaction)
 }
 
-// HandleMatrixMessageRemove deletes a message from SimpleX.
+// HandleMatrixMessageRemove deletes a message from SimpleX, either for
+// everyone or only locally depending on the redaction reason and the portal's
+// delete mode.
 func (s *SimplexClient) HandleMatrixMessageRemove(ctx context.Context, msg *bridgev2.MatrixMessageRemove) error {
 	if s.Client == nil {
 		return bridgev2.ErrNotLoggedIn
@@ -302,162 +370,10 @@ func (s *SimplexClient) HandleMatrixMessageRemove(ctx context.Context, msg *brid
 	if err != nil {
 		return fmt.Errorf("failed to parse message ID: %w", err)
 	}
-	return s.Client.DeleteChatItem(chatType, chatID, itemID, simplexclient.DeleteModeBroadcast)
-}
-
-// ffmpegThumbnailBase64 generates a small JPEG thumbnail from a media file using
-// ffmpeg and returns it as a base64 data URI. The thumbnail is kept tiny (max 64px)
-// at low quality so the base64 fits within SimpleX's ~16KB message size limit.
-// Returns empty string on failure.
-func ffmpegThumbnailBase64(ctx context.Context, filePath string) string {
-	log := zerolog.Ctx(ctx)
-	thumbPath := filePath + ".thumb.jpg"
-	defer os.Remove(thumbPath)
-
-	// Extract a single frame, scale to max 256px, encode as low quality JPEG.
-	// SimpleX has a ~16KB message size limit and the thumbnail is embedded as
-	// base64 inside the JSON payload, so aim for ~6-10KB base64 (q:v 10).
-	// A larger but compressed image gives a better preview than a tiny sharp one.
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-i", filePath,
-		"-vframes", "1",
-		"-vf", "scale='min(256,iw)':'min(256,ih)':force_original_aspect_ratio=decrease",
-		"-q:v", "10",
-		"-y",
-		thumbPath,
-	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Warn().Err(err).Str("output", string(out)).Msg("ffmpeg thumbnail generation failed")
-		return ""
-	}
-
-	thumbData, err := os.ReadFile(thumbPath)
-	if err != nil || len(thumbData) == 0 {
-		return ""
-	}
-
-	return "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(thumbData)
-}
-
-var urlRe = regexp.MustCompile(`https?://[^\s"'<>]+`)
-
-// extractFirstURL returns the first http/https URL found in text, or "".
-func extractFirstURL(text string) string {
-	return urlRe.FindString(text)
-}
-
-var (
-	ogMetaRe   = regexp.MustCompile(`(?i)<meta[^>]+>`)
-	propertyRe = regexp.MustCompile(`(?i)property=["'](og:[^"']+)["']`)
-	contentRe  = regexp.MustCompile(`(?i)content=["']([^"']*)["']`)
-	titleTagRe = regexp.MustCompile(`(?i)<title[^>]*>([^<]+)</title>`)
-)
-
-func extractOGTag(html, prop string) string {
-	for _, tag := range ogMetaRe.FindAllString(html, -1) {
-		m := propertyRe.FindStringSubmatch(tag)
-		if m == nil || !strings.EqualFold(m[1], prop) {
-			continue
-		}
-		c := contentRe.FindStringSubmatch(tag)
-		if c != nil {
-			return c[1]
-		}
-	}
-	return ""
-}
-
-// fetchLinkPreview fetches the page at uri and extracts OG metadata plus a
-// thumbnail image. Returns nil if no useful data could be retrieved.
-func fetchLinkPreview(ctx context.Context, client *http.Client, uri string) *simplexclient.LinkPreview {
-	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
-	if err != nil {
-		return nil
-	}
-	req.Header.Set("User-Agent", "TelegramBot (like TwitterBot)")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil
-	}
-	ct := resp.Header.Get("Content-Type")
-	if !strings.Contains(ct, "text/html") && !strings.Contains(ct, "xhtml") {
-		return nil
-	}
-
-	raw, err := io.ReadAll(io.LimitReader(resp.Body, 256*1024))
-	if err != nil {
-		return nil
-	}
-	page := string(raw)
-
-	title := extractOGTag(page, "og:title")
-	if title == "" {
-		if m := titleTagRe.FindStringSubmatch(page); m != nil {
-			title = strings.TrimSpace(m[1])
-		}
-	}
-	if title == "" {
-		return nil
+	mode := getRedactionDeleteMode(msg)
+	if mode == simplexclient.DeleteModeBroadcast && chatType == simplexclient.ChatTypeGroup && !s.IsThisUser(ctx, msg.TargetMessage.SenderID) {
+		// Other members' messages can only be deleted for everyone by moderators.
+		return s.Client.DeleteMemberChatItem(chatID, itemID)
 	}
-
-	preview := &simplexclient.LinkPreview{
-		URI:         uri,
-		Title:       title,
-		Description: extractOGTag(page, "og:description"),
-	}
-
-	// Fetch the og:image and generate a thumbnail via ffmpeg.
-	if imgURL := extractOGTag(page, "og:image"); imgURL != "" {
-		if thumb := fetchURLThumbnailBase64(ctx, client, imgURL); thumb != "" {
-			preview.Image = thumb
-		}
-	}
-
-	return preview
-}
-
-// fetchURLThumbnailBase64 downloads an image URL, writes it to a temp file,
-// and returns a base64 thumbnail the same way ffmpegThumbnailBase64 does.
-func fetchURLThumbnailBase64(ctx context.Context, client *http.Client, imgURL string) string {
-	req, err := http.NewRequestWithContext(ctx, "GET", imgURL, nil)
-	if err != nil {
-		return ""
-	}
-	req.Header.Set("User-Agent", "TelegramBot (like TwitterBot)")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return ""
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
-	if err != nil || len(data) == 0 {
-		return ""
-	}
-
-	tmp, err := os.CreateTemp("", "preview-img-*")
-	if err != nil {
-		return ""
-	}
-	tmpPath := tmp.Name()
-	defer os.Remove(tmpPath)
-
-	if _, err = tmp.Write(data); err != nil {
-		tmp.Close()
-		return ""
-	}
-	tmp.Close()
-
-	return ffmpegThumbnailBase64(ctx, tmpPath)
+	return s.Client.DeleteChatItem(chatType, chatID, itemID, mode)
 }
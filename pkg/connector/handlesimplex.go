@@ -20,11 +20,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mime"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -128,11 +128,58 @@ func (s *SimplexClient) handleSimplexEvent(ctx context.Context, evt simplexclien
 			log.Err(err).Msg("Failed to unmarshal rcvFileComplete event")
 			return
 		}
-		// Re-process the chat item now that the file is downloaded.
-		s.handleNewChatItems(ctx, simplexclient.NewChatItemsEvent{
-			User:      data.User,
-			ChatItems: []simplexclient.AChatItem{data.ChatItem},
-		})
+		s.handleRcvFileComplete(ctx, data)
+
+	case "rcvFileProgressXFTP":
+		var data simplexclient.RcvFileProgressEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal rcvFileProgressXFTP event")
+			return
+		}
+		s.handleRcvFileProgress(ctx, data)
+
+	case "rcvFileError":
+		var data simplexclient.RcvFileErrorEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal rcvFileError event")
+			return
+		}
+		log.Warn().RawJSON("agent_error", data.AgentError).Msg("Failed to receive file")
+		if data.ChatItem != nil {
+			s.handleRcvFileFailed(ctx, data.User, *data.ChatItem)
+		}
+
+	case "rcvFileSndCancelled":
+		var data simplexclient.RcvFileSndCancelledEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal rcvFileSndCancelled event")
+			return
+		}
+		s.handleRcvFileFailed(ctx, data.User, data.ChatItem)
+
+	case "sndFileProgressXFTP":
+		var data simplexclient.SndFileProgressEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal sndFileProgressXFTP event")
+			return
+		}
+		s.handleSndFileProgress(ctx, data)
+
+	case "sndFileCompleteXFTP":
+		var data simplexclient.SndFileCompleteEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal sndFileCompleteXFTP event")
+			return
+		}
+		s.handleSndFileComplete(ctx, data)
+
+	case "sndFileError":
+		var data simplexclient.SndFileErrorEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal sndFileError event")
+			return
+		}
+		s.handleSndFileError(ctx, data)
 
 	case "receivedContactRequest":
 		var data simplexclient.ReceivedContactRequestEvent
@@ -142,6 +189,22 @@ func (s *SimplexClient) handleSimplexEvent(ctx context.Context, evt simplexclien
 		}
 		s.handleReceivedContactRequest(ctx, data)
 
+	case "userJoinedGroup":
+		var data simplexclient.UserJoinedGroupEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal userJoinedGroup event")
+			return
+		}
+		s.handleUserJoinedGroup(ctx, data)
+
+	case "acceptingBusinessRequest":
+		var data simplexclient.AcceptingBusinessRequestEvent
+		if err := json.Unmarshal(evt.Raw, &data); err != nil {
+			log.Err(err).Msg("Failed to unmarshal acceptingBusinessRequest event")
+			return
+		}
+		s.handleAcceptingBusinessRequest(ctx, data)
+
 	case "chatError":
 		log.Warn().RawJSON("error_data", evt.Raw).Msg("SimpleX chat error event")
 
@@ -150,74 +213,96 @@ func (s *SimplexClient) handleSimplexEvent(ctx context.Context, evt simplexclien
 	}
 }
 
-// handleNewChatItems handles incoming messages.
+// handleNewChatItems handles incoming messages. Files that haven't been
+// downloaded yet are bridged as a placeholder, which is edited once the
+// download completes or fails.
 func (s *SimplexClient) handleNewChatItems(ctx context.Context, data simplexclient.NewChatItemsEvent) {
 	for _, aci := range data.ChatItems {
-		item := aci.ChatItem
-
-		// Skip file messages where the file hasn't been downloaded yet.
-		// The rcvFileComplete event will re-trigger this handler once the file is ready.
-		if item.File != nil && item.File.GetFilePath() == "" {
-			zerolog.Ctx(ctx).Debug().
-				Int64("item_id", item.Meta.ItemID).
-				Str("file_name", item.File.FileName).
-				Msg("Skipping chat item with pending file download, waiting for rcvFileComplete")
-			continue
-		}
+		s.queueChatItem(ctx, aci)
+	}
+}
 
-		portalKey := s.makePortalKeyFromChatInfo(aci.ChatInfo)
-		sender := s.makeEventSenderFromDir(item.ChatDir)
+// queueChatItem queues a single chat item as a Matrix message.
+func (s *SimplexClient) queueChatItem(ctx context.Context, aci simplexclient.AChatItem) {
+	s.UserLogin.QueueRemoteEvent(s.makeChatItemEvent(aci))
+}
 
-		// Resolve directRcv sender: use contact from chat info
-		if item.ChatDir.Type == "directRcv" && aci.ChatInfo.Contact != nil {
-			sender = s.makeEventSenderFromContact(aci.ChatInfo.Contact)
-		}
+// makeChatItemEvent returns the remote event that bridges a chat item as a
+// new Matrix message.
+func (s *SimplexClient) makeChatItemEvent(aci simplexclient.AChatItem) *simplevent.Message[*simplexclient.ChatItem] {
+	item := aci.ChatItem
+	portalKey := s.makePortalKeyFromChatInfo(aci.ChatInfo)
+	sender := s.makeEventSenderFromDir(item.ChatDir)
 
-		ts := parseSimplexTime(item.Meta.CreatedAt)
-		msgID := simplexid.MakeMessageID(item.Meta.ItemID)
+	// Resolve directRcv sender: use contact from chat info
+	if item.ChatDir.Type == "directRcv" && aci.ChatInfo.Contact != nil {
+		sender = s.makeEventSenderFromContact(aci.ChatInfo.Contact)
+	}
 
-		// For messages we sent ourselves, set TransactionID = msgID so that
-		// AddPendingToIgnore (registered in HandleMatrixMessage) can suppress
-		// the echo that simplex-chat pushes as an async event after every send.
-		var txnID networkid.TransactionID
-		if item.ChatDir.Type == "directSnd" || item.ChatDir.Type == "groupSnd" {
-			txnID = networkid.TransactionID(msgID)
-		}
+	ts := parseSimplexTime(item.Meta.CreatedAt)
+	msgID := simplexid.MakeMessageID(item.Meta.ItemID)
 
-		s.UserLogin.QueueRemoteEvent(&simplevent.Message[*simplexclient.ChatItem]{
-			EventMeta: simplevent.EventMeta{
-				Type: bridgev2.RemoteEventMessage,
-				LogContext: func(c zerolog.Context) zerolog.Context {
-					return c.Int64("item_id", item.Meta.ItemID)
-				},
-				PortalKey:    portalKey,
-				CreatePortal: true,
-				Sender:       sender,
-				Timestamp:    ts,
+	// For messages we sent ourselves, set TransactionID = msgID so that
+	// AddPendingToIgnore (registered in HandleMatrixMessage) can suppress
+	// the echo that simplex-chat pushes as an async event after every send.
+	var txnID networkid.TransactionID
+	if sender.IsFromMe {
+		txnID = networkid.TransactionID(msgID)
+	}
+
+	return &simplevent.Message[*simplexclient.ChatItem]{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventMessage,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Int64("item_id", item.Meta.ItemID)
 			},
-			Data:          &item,
-			ID:            msgID,
-			TransactionID: txnID,
-			ConvertMessageFunc: func(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, data *simplexclient.ChatItem) (*bridgev2.ConvertedMessage, error) {
-				cm := convertChatItemToMatrix(data)
-				// If a file part needs to be uploaded, do it now.
-				for _, part := range cm.Parts {
-					if filePath, ok := part.Extra["fi.mau.simplex.file_path"].(string); ok {
-						delete(part.Extra, "fi.mau.simplex.file_path")
-						filePath = s.resolveSimplexFilePath(filePath)
-						if err := uploadFilePartToMatrix(ctx, portal, intent, part, filePath); err != nil {
-							zerolog.Ctx(ctx).Err(err).Str("file_path", filePath).Msg("Failed to upload file to Matrix")
-							part.Content = &event.MessageEventContent{
-								MsgType: event.MsgNotice,
-								Body:    "[File transfer failed: " + err.Error() + "]",
-							}
-						}
-					}
+			PortalKey:    portalKey,
+			CreatePortal: true,
+			Sender:       sender,
+			Timestamp:    ts,
+		},
+		Data:          &item,
+		ID:            msgID,
+		TransactionID: txnID,
+		ConvertMessageFunc: func(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, data *simplexclient.ChatItem) (*bridgev2.ConvertedMessage, error) {
+			cm := s.convertChatItem(ctx, portal, intent, data)
+			s.addQuoteFallback(ctx, portal, cm, data, nil)
+			addForwardedHeader(cm, data)
+			return cm, nil
+		},
+	}
+}
+
+// convertChatItem converts a chat item and uploads its file to Matrix if it
+// has been downloaded. Files that haven't been downloaded are converted to a
+// notice describing the transfer status.
+func (s *SimplexClient) convertChatItem(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, data *simplexclient.ChatItem) *bridgev2.ConvertedMessage {
+	cm := convertChatItemToMatrix(data)
+	if data.File != nil && data.File.GetFilePath() == "" && data.Meta.ItemDeleted == nil && s.Main.canDownloadFile(data.File.FileSize) {
+		cm.Parts = []*bridgev2.ConvertedMessagePart{s.fileStatusPart(portal, data)}
+	}
+	if mc := parseMsgContent(data); mc.Type == "link" && mc.Preview != nil && mc.Preview.Image != "" {
+		for _, part := range cm.Parts {
+			if len(part.Content.BeeperLinkPreviews) > 0 {
+				uploadLinkPreviewImage(ctx, portal, intent, part.Content.BeeperLinkPreviews[0], mc.Preview.Image)
+			}
+		}
+	}
+	// If a file part needs to be uploaded, do it now.
+	for _, part := range cm.Parts {
+		if filePath, ok := part.Extra["fi.mau.simplex.file_path"].(string); ok {
+			delete(part.Extra, "fi.mau.simplex.file_path")
+			filePath = s.resolveSimplexFilePath(filePath)
+			if err := uploadFilePartToMatrix(ctx, portal, intent, part, filePath, s.Main.Config.MaxIncomingFileSize); err != nil {
+				zerolog.Ctx(ctx).Err(err).Str("file_path", filePath).Msg("Failed to upload file to Matrix")
+				part.Content = &event.MessageEventContent{
+					MsgType: event.MsgNotice,
+					Body:    "[File transfer failed: " + err.Error() + "]",
 				}
-				return cm, nil
-			},
-		})
+			}
+		}
 	}
+	return cm
 }
 
 // convertChatItemToMatrix converts a SimpleX ChatItem to a Matrix ConvertedMessage.
@@ -239,29 +324,20 @@ func convertChatItemToMatrix(item *simplexclient.ChatItem) *bridgev2.ConvertedMe
 	}
 
 	// Parse MsgContent for type-specific handling (link previews, etc.)
-	var mc simplexclient.MsgContent
-	if len(item.Content.MsgContent) > 0 {
-		_ = json.Unmarshal(item.Content.MsgContent, &mc)
-	}
+	mc := parseMsgContent(item)
 
-	// For link-type messages, render as a formatted text message with title
-	// and description (preview images are not bridged to Matrix).
+	// Link previews are attached as com.beeper.linkpreviews so clients render
+	// a native preview card. The image is uploaded by convertChatItem.
+	var linkPreviews []*event.BeeperLinkPreview
 	if mc.Type == "link" && mc.Preview != nil {
-		preview := mc.Preview
-		if !strings.Contains(body, preview.URI) {
-			if body != "" {
-				body += "\n" + preview.URI
-			} else {
-				body = preview.URI
-			}
-		}
-		var htmlBuf strings.Builder
-		fmt.Fprintf(&htmlBuf, `<strong><a href="%s">%s</a></strong>`,
-			escapeHTML(preview.URI), escapeHTML(preview.Title))
-		if preview.Description != "" {
-			fmt.Fprintf(&htmlBuf, "<br><em>%s</em>", escapeHTML(preview.Description))
-		}
-		html = htmlBuf.String()
+		linkPreviews = []*event.BeeperLinkPreview{{
+			MatchedURL: mc.Preview.URI,
+			LinkPreview: event.LinkPreview{
+				CanonicalURL: mc.Preview.URI,
+				Title:        mc.Preview.Title,
+				Description:  mc.Preview.Description,
+			},
+		}}
 	}
 
 	if item.Meta.ItemDeleted != nil {
@@ -283,10 +359,6 @@ func convertChatItemToMatrix(item *simplexclient.ChatItem) *bridgev2.ConvertedMe
 	if item.File != nil && item.File.GetFilePath() != "" {
 		// Determine the Matrix message type from the SimpleX MsgContent type.
 		msgType := event.MsgFile
-		var mc simplexclient.MsgContent
-		if len(item.Content.MsgContent) > 0 {
-			_ = json.Unmarshal(item.Content.MsgContent, &mc)
-		}
 		switch mc.Type {
 		case "image":
 			msgType = event.MsgImage
@@ -310,11 +382,18 @@ func convertChatItemToMatrix(item *simplexclient.ChatItem) *bridgev2.ConvertedMe
 				Size: int(item.File.FileSize),
 			},
 		}
+		if mc.Duration != nil {
+			content.Info.Duration = *mc.Duration * 1000
+		}
+		if mc.Type == "voice" {
+			content.MSC3245Voice = &event.MSC3245Voice{}
+			content.MSC1767Audio = &event.MSC1767Audio{Duration: content.Info.Duration}
+		}
 		return &bridgev2.ConvertedMessage{
 			ReplyTo: replyTo,
 			Parts: []*bridgev2.ConvertedMessagePart{{
-				ID:   networkid.PartID("file"),
-				Type: event.EventMessage,
+				ID:      networkid.PartID("file"),
+				Type:    event.EventMessage,
 				Content: content,
 				Extra: map[string]any{
 					"fi.mau.simplex.file_path": item.File.GetFilePath(),
@@ -323,9 +402,31 @@ func convertChatItemToMatrix(item *simplexclient.ChatItem) *bridgev2.ConvertedMe
 		}
 	}
 
+	// A file that wasn't downloaded (e.g. because it exceeds the size limit)
+	// is bridged as a notice that keeps the caption.
+	if item.File != nil {
+		notice := fmt.Sprintf("[File not received: %s, %s]", item.File.FileName, formatFileSize(item.File.FileSize))
+		if body != "" {
+			notice = body + "\n\n" + notice
+		}
+		return &bridgev2.ConvertedMessage{
+			ReplyTo: replyTo,
+			Parts: []*bridgev2.ConvertedMessagePart{{
+				ID:   networkid.PartID(""),
+				Type: event.EventMessage,
+				Content: &event.MessageEventContent{
+					MsgType: event.MsgNotice,
+					Body:    notice,
+				},
+				Extra: map[string]any{},
+			}},
+		}
+	}
+
 	content := &event.MessageEventContent{
-		MsgType: event.MsgText,
-		Body:    body,
+		MsgType:            event.MsgText,
+		Body:               body,
+		BeeperLinkPreviews: linkPreviews,
 	}
 	if html != "" {
 		content.Format = event.FormatHTML
@@ -343,8 +444,25 @@ func convertChatItemToMatrix(item *simplexclient.ChatItem) *bridgev2.ConvertedMe
 	}
 }
 
-// handleChatItemUpdated handles message edits.
+// handleChatItemUpdated handles message edits. Updates of live messages that
+// are still being typed are throttled.
 func (s *SimplexClient) handleChatItemUpdated(ctx context.Context, data simplexclient.ChatItemUpdatedEvent) {
+	seq, ok := s.shouldBridgeLiveEdit(ctx, data)
+	if !ok {
+		return
+	}
+	s.queueChatItemEdit(ctx, data, seq)
+}
+
+// queueChatItemEdit queues an edit of a chat item to be bridged. liveSeq is
+// the sequence number of live message updates, or zero for normal edits.
+func (s *SimplexClient) queueChatItemEdit(ctx context.Context, data simplexclient.ChatItemUpdatedEvent, liveSeq uint64) {
+	s.UserLogin.QueueRemoteEvent(s.makeChatItemEditEvent(data, liveSeq))
+}
+
+// makeChatItemEditEvent returns the remote event that bridges an update of a
+// chat item as an edit of its Matrix message.
+func (s *SimplexClient) makeChatItemEditEvent(data simplexclient.ChatItemUpdatedEvent, liveSeq uint64) *simplevent.Message[*simplexclient.ChatItem] {
 	item := data.ChatItem.ChatItem
 	portalKey := s.makePortalKeyFromChatInfo(data.ChatItem.ChatInfo)
 	sender := s.makeEventSenderFromDir(item.ChatDir)
@@ -355,7 +473,7 @@ func (s *SimplexClient) handleChatItemUpdated(ctx context.Context, data simplexc
 	ts := parseSimplexTime(item.Meta.CreatedAt)
 	msgID := simplexid.MakeMessageID(item.Meta.ItemID)
 
-	s.UserLogin.QueueRemoteEvent(&simplevent.Message[*simplexclient.ChatItem]{
+	return &simplevent.Message[*simplexclient.ChatItem]{
 		EventMeta: simplevent.EventMeta{
 			Type: bridgev2.RemoteEventEdit,
 			LogContext: func(c zerolog.Context) zerolog.Context {
@@ -368,16 +486,15 @@ func (s *SimplexClient) handleChatItemUpdated(ctx context.Context, data simplexc
 		TargetMessage: msgID,
 		Data:          &item,
 		ConvertEditFunc: func(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message, data *simplexclient.ChatItem) (*bridgev2.ConvertedEdit, error) {
-			cm := convertChatItemToMatrix(data)
+			if !s.claimLiveEdit(data.Meta.ItemID, liveSeq) {
+				// A newer update of the live message was already bridged.
+				return nil, bridgev2.ErrIgnoringRemoteEvent
+			}
+			cm := s.convertChatItem(ctx, portal, intent, data)
+			s.addQuoteFallback(ctx, portal, cm, data, nil)
+			addForwardedHeader(cm, data)
 			editParts := make([]*bridgev2.ConvertedEditPart, 0, len(cm.Parts))
 			for _, p := range cm.Parts {
-				if filePath, ok := p.Extra["fi.mau.simplex.file_path"].(string); ok {
-					delete(p.Extra, "fi.mau.simplex.file_path")
-					filePath = s.resolveSimplexFilePath(filePath)
-					if err := uploadFilePartToMatrix(ctx, portal, intent, p, filePath); err != nil {
-						zerolog.Ctx(ctx).Err(err).Str("file_path", filePath).Msg("Failed to upload file to Matrix (edit)")
-					}
-				}
 				// Match this converted part to the existing database message by PartID.
 				var existingPart *database.Message
 				for _, ex := range existing {
@@ -392,6 +509,10 @@ func (s *SimplexClient) handleChatItemUpdated(ctx context.Context, data simplexc
 				if existingPart == nil {
 					continue
 				}
+				// The file was downloaded on demand, it can't be downloaded again.
+				if meta, ok := existingPart.Metadata.(*simplexid.MessageMetadata); ok && meta.PendingFileID != 0 && data.File != nil && data.File.GetFilePath() != "" {
+					meta.PendingFileID = 0
+				}
 				editParts = append(editParts, &bridgev2.ConvertedEditPart{
 					Part:    existingPart,
 					Type:    p.Type,
@@ -401,7 +522,7 @@ func (s *SimplexClient) handleChatItemUpdated(ctx context.Context, data simplexc
 			}
 			return &bridgev2.ConvertedEdit{ModifiedParts: editParts}, nil
 		},
-	})
+	}
 }
 
 // handleChatItemsDeleted handles message deletions.
@@ -411,6 +532,8 @@ func (s *SimplexClient) handleChatItemsDeleted(ctx context.Context, data simplex
 			continue
 		}
 		item := del.DeletedChatItem.ChatItem
+		// Cancelled live messages are deleted, so their pending updates are dropped.
+		s.forgetLiveMessage(item.Meta.ItemID)
 		portalKey := s.makePortalKeyFromChatInfo(del.DeletedChatItem.ChatInfo)
 		msgID := simplexid.MakeMessageID(item.Meta.ItemID)
 
@@ -420,7 +543,7 @@ func (s *SimplexClient) handleChatItemsDeleted(ctx context.Context, data simplex
 			sender = s.makeEventSenderFromContact(del.DeletedChatItem.ChatInfo.Contact)
 		}
 
-		s.UserLogin.QueueRemoteEvent(&simplevent.MessageRemove{
+		evt := &simplevent.MessageRemove{
 			EventMeta: simplevent.EventMeta{
 				Type: bridgev2.RemoteEventMessageRemove,
 				LogContext: func(c zerolog.Context) zerolog.Context {
@@ -431,6 +554,22 @@ func (s *SimplexClient) handleChatItemsDeleted(ctx context.Context, data simplex
 				Timestamp: time.Now(),
 			},
 			TargetMessage: msgID,
+		}
+		moderator := getModerator(del)
+		if moderator == nil {
+			s.UserLogin.QueueRemoteEvent(evt)
+			continue
+		}
+		// Moderated messages are removed by the moderator, with a reason.
+		evt.Sender = s.makeEventSenderFromMember(moderator)
+		if groupInfo := del.DeletedChatItem.ChatInfo.GroupInfo; groupInfo != nil && moderator.MemberID == groupInfo.Membership.MemberID {
+			loginID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
+			evt.Sender = bridgev2.EventSender{IsFromMe: true, Sender: simplexid.MakeUserID(loginID)}
+		}
+		s.UserLogin.QueueRemoteEvent(&moderatedMessageRemove{
+			MessageRemove: evt,
+			Source:        s.UserLogin,
+			Reason:        "Moderated by " + moderator.Profile.DisplayName,
 		})
 	}
 }
@@ -449,7 +588,7 @@ func (s *SimplexClient) handleChatItemReaction(ctx context.Context, data simplex
 		if reaction.ChatReaction.ChatDir.Type == "directRcv" && reaction.ChatInfo.Contact != nil {
 			sender = s.makeEventSenderFromContact(reaction.ChatInfo.Contact)
 		}
-	} else {
+	} else if reaction.ChatInfo.Type != "group" {
 		loginID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
 		sender = bridgev2.EventSender{IsFromMe: true, Sender: simplexid.MakeUserID(loginID)}
 	}
@@ -457,6 +596,17 @@ func (s *SimplexClient) handleChatItemReaction(ctx context.Context, data simplex
 	portalKey := s.makePortalKeyFromChatInfo(reaction.ChatInfo)
 	targetMsgID := simplexid.MakeMessageID(reaction.ChatReaction.ChatItem.Meta.ItemID)
 	emoji := reaction.ChatReaction.Reaction.Emoji
+	if sender.Sender == "" || sender.Sender == "unknown" {
+		var ok bool
+		sender, ok = s.resolveGroupReactionSender(ctx, reaction, data.Added)
+		if !ok {
+			zerolog.Ctx(ctx).Warn().
+				Str("emoji", emoji).
+				Int64("target_item_id", reaction.ChatReaction.ChatItem.Meta.ItemID).
+				Msg("Couldn't find who reacted, ignoring reaction")
+			return
+		}
+	}
 
 	var evtType bridgev2.RemoteEventType
 	if data.Added {
@@ -478,6 +628,7 @@ func (s *SimplexClient) handleChatItemReaction(ctx context.Context, data simplex
 			Timestamp: time.Now(),
 		},
 		TargetMessage: targetMsgID,
+		EmojiID:       networkid.EmojiID(emoji),
 		Emoji:         emoji,
 	})
 }
@@ -489,12 +640,16 @@ func (s *SimplexClient) handleReceivedContactRequest(ctx context.Context, data s
 	log.Info().
 		Int64("contact_req_id", req.ContactRequestID).
 		Str("display_name", req.LocalDisplayName).
+		Bool("incognito", s.Main.Config.AcceptContactsIncognito).
 		Msg("Auto-accepting incoming contact request")
 
-	contact, err := s.Client.AcceptContact(req.ContactRequestID)
+	contact, businessChat, err := s.Client.AcceptContact(req.ContactRequestID, s.Main.Config.AcceptContactsIncognito)
 	if err != nil {
 		log.Err(err).Int64("contact_req_id", req.ContactRequestID).Msg("Failed to auto-accept contact request")
 		return
+	} else if businessChat != nil {
+		s.handleAcceptingBusinessRequest(ctx, simplexclient.AcceptingBusinessRequestEvent{GroupInfo: *businessChat})
+		return
 	}
 
 	// Create the DM portal for this newly accepted contact.
@@ -512,6 +667,46 @@ func (s *SimplexClient) handleReceivedContactRequest(ctx context.Context, data s
 	})
 }
 
+// handleUserJoinedGroup creates the portal of a group we joined, e.g. with a
+// group link.
+func (s *SimplexClient) handleUserJoinedGroup(ctx context.Context, data simplexclient.UserJoinedGroupEvent) {
+	zerolog.Ctx(ctx).Info().
+		Int64("group_id", data.GroupInfo.GroupID).
+		Str("display_name", data.GroupInfo.LocalDisplayName).
+		Msg("Joined group")
+	s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatResync,
+			PortalKey: networkid.PortalKey{
+				ID:       simplexid.MakeGroupPortalID(data.GroupInfo.GroupID),
+				Receiver: s.UserLogin.ID,
+			},
+			CreatePortal: true,
+		},
+		GetChatInfoFunc: s.GetChatInfo,
+	})
+}
+
+// handleAcceptingBusinessRequest creates the portal of a business chat that
+// was created by accepting a request to our business address.
+func (s *SimplexClient) handleAcceptingBusinessRequest(ctx context.Context, data simplexclient.AcceptingBusinessRequestEvent) {
+	zerolog.Ctx(ctx).Info().
+		Int64("group_id", data.GroupInfo.GroupID).
+		Str("display_name", data.GroupInfo.LocalDisplayName).
+		Msg("Accepted business chat request")
+	s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatResync,
+			PortalKey: networkid.PortalKey{
+				ID:       simplexid.MakeGroupPortalID(data.GroupInfo.GroupID),
+				Receiver: s.UserLogin.ID,
+			},
+			CreatePortal: true,
+		},
+		GetChatInfoFunc: s.GetChatInfo,
+	})
+}
+
 // handleContactConnected handles a new contact being connected.
 func (s *SimplexClient) handleContactConnected(ctx context.Context, data simplexclient.ContactConnectedEvent) {
 	contact := data.Contact
@@ -588,7 +783,9 @@ func (s *SimplexClient) handleGroupUpdated(ctx context.Context, data simplexclie
 	})
 }
 
-// handleRcvFileDescrReady auto-accepts incoming file downloads so they proceed to rcvFileComplete.
+// handleRcvFileDescrReady accepts incoming file downloads allowed by the file
+// receive policy so they proceed to rcvFileComplete. Other files have already
+// been bridged as a notice and can be downloaded on demand.
 func (s *SimplexClient) handleRcvFileDescrReady(ctx context.Context, data simplexclient.RcvFileDescrReadyEvent) {
 	log := zerolog.Ctx(ctx)
 	fileID := data.RcvFileTransfer.FileID
@@ -596,17 +793,53 @@ func (s *SimplexClient) handleRcvFileDescrReady(ctx context.Context, data simple
 		Int64("file_id", fileID).
 		Str("file_name", data.RcvFileTransfer.FileName).
 		Int64("file_size", data.RcvFileTransfer.FileSize).
-		Msg("Auto-accepting incoming file download")
+		Msg("Incoming file ready for download")
 
+	if maxSize := s.Main.Config.MaxIncomingFileSize; maxSize > 0 && data.RcvFileTransfer.FileSize > maxSize {
+		log.Info().
+			Int64("file_id", fileID).
+			Int64("max_size", maxSize).
+			Msg("Not downloading file that exceeds the size limit")
+		return
+	}
+	portal, err := s.Main.Bridge.GetExistingPortalByKey(ctx, s.makePortalKeyFromChatInfo(data.ChatItem.ChatInfo))
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal for file receive policy")
+	}
+	policy := s.Main.getFilePolicy(portal)
+	if ok, reason := policy.allows(data.RcvFileTransfer.FileName, data.RcvFileTransfer.FileSize); !ok {
+		log.Info().
+			Int64("file_id", fileID).
+			Str("reason", reason).
+			Msg("Not downloading file automatically")
+		return
+	}
 	if err := s.Client.ReceiveFile(fileID); err != nil {
 		log.Err(err).Int64("file_id", fileID).Msg("Failed to auto-accept file download")
 	}
 }
 
+// parseMsgContent parses the MsgContent of a chat item, returning an empty
+// MsgContent if there is none.
+func parseMsgContent(item *simplexclient.ChatItem) simplexclient.MsgContent {
+	var mc simplexclient.MsgContent
+	if len(item.Content.MsgContent) > 0 {
+		_ = json.Unmarshal(item.Content.MsgContent, &mc)
+	}
+	return mc
+}
+
+// fileCaption returns the caption of a file message.
+func fileCaption(item *simplexclient.ChatItem) string {
+	return parseMsgContent(item).Text
+}
+
 // syncChats creates/updates portals for all existing contacts and groups.
 // On first connect it does a full sync including member lists.
 // On reconnects (ChatsSynced already true) it only updates names/avatars/topics
 // to avoid kicking and re-inviting all members in Matrix rooms.
+// Messages received while the bridge was disconnected are caught up on for
+// every chat that already has a room.
 func (s *SimplexClient) syncChats(ctx context.Context) {
 	log := zerolog.Ctx(ctx)
 	if s.Client == nil {
@@ -644,6 +877,9 @@ func (s *SimplexClient) syncChats(ctx context.Context) {
 				ID:       simplexid.MakeDMPortalID(contact.ContactID),
 				Receiver: s.UserLogin.ID,
 			}
+			if s.isDeletedChat(ctx, portalKey) {
+				continue
+			}
 			s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
 				EventMeta: simplevent.EventMeta{
 					Type:         bridgev2.RemoteEventChatResync,
@@ -652,6 +888,7 @@ func (s *SimplexClient) syncChats(ctx context.Context) {
 				},
 				GetChatInfoFunc: getChatInfoFunc,
 			})
+			s.catchUpChat(ctx, portalKey, simplexclient.ChatTypeDirect, contact.ContactID)
 		}
 	}
 
@@ -664,6 +901,9 @@ func (s *SimplexClient) syncChats(ctx context.Context) {
 				ID:       simplexid.MakeGroupPortalID(group.GroupID),
 				Receiver: s.UserLogin.ID,
 			}
+			if s.isDeletedChat(ctx, portalKey) {
+				continue
+			}
 			s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
 				EventMeta: simplevent.EventMeta{
 					Type:         bridgev2.RemoteEventChatResync,
@@ -672,6 +912,25 @@ func (s *SimplexClient) syncChats(ctx context.Context) {
 				},
 				GetChatInfoFunc: getChatInfoFunc,
 			})
+			s.catchUpChat(ctx, portalKey, simplexclient.ChatTypeGroup, group.GroupID)
+		}
+	}
+
+	if folderID := s.getNoteFolderID(ctx, loginID); folderID != 0 {
+		portalKey := networkid.PortalKey{
+			ID:       simplexid.MakeNotesPortalID(folderID),
+			Receiver: s.UserLogin.ID,
+		}
+		if !s.isDeletedChat(ctx, portalKey) {
+			s.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
+				EventMeta: simplevent.EventMeta{
+					Type:         bridgev2.RemoteEventChatResync,
+					PortalKey:    portalKey,
+					CreatePortal: true,
+				},
+				GetChatInfoFunc: getChatInfoFunc,
+			})
+			s.catchUpChat(ctx, portalKey, simplexclient.ChatTypeLocal, folderID)
 		}
 	}
 
@@ -682,6 +941,54 @@ func (s *SimplexClient) syncChats(ctx context.Context) {
 	}
 }
 
+// getNoteFolderID returns the ID of the user's notes chat, or zero if it
+// doesn't have one. Finding it requires listing every chat, so the ID is
+// stored in the login metadata and only looked up again if it's gone.
+func (s *SimplexClient) getNoteFolderID(ctx context.Context, userID int64) int64 {
+	log := zerolog.Ctx(ctx)
+	meta := s.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
+	if meta.NoteFolderID != 0 {
+		chat, err := s.Client.GetChat(simplexclient.ChatTypeLocal, meta.NoteFolderID, simplexclient.ChatPagination{
+			Type:  simplexclient.PaginationLast,
+			Count: 1,
+		})
+		if err == nil && chat != nil && chat.ChatInfo.NoteFolder != nil {
+			return meta.NoteFolderID
+		}
+		log.Warn().Err(err).Int64("note_folder_id", meta.NoteFolderID).Msg("Stored notes chat not found, looking it up again")
+	}
+	folders, err := s.Client.ListNoteFolders(userID)
+	if err != nil {
+		log.Err(err).Msg("Failed to list note folders during sync")
+		return 0
+	} else if len(folders) == 0 {
+		return 0
+	}
+	// Saved along with ChatsSynced at the end of the sync.
+	meta.NoteFolderID = folders[0].NoteFolderID
+	return meta.NoteFolderID
+}
+
+// isDeletedChat checks whether a chat's portal was deleted from Matrix only for
+// the user. Chats whose portal was recreated by a new message are no longer
+// considered deleted.
+func (s *SimplexClient) isDeletedChat(ctx context.Context, portalKey networkid.PortalKey) bool {
+	meta := s.UserLogin.Metadata.(*simplexid.UserLoginMetadata)
+	idx := slices.Index(meta.DeletedChats, portalKey.ID)
+	if idx < 0 {
+		return false
+	}
+	portal, err := s.Main.Bridge.GetExistingPortalByKey(ctx, portalKey)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("portal_id", string(portalKey.ID)).Msg("Failed to get portal of deleted chat")
+		return true
+	} else if portal == nil || portal.MXID == "" {
+		return true
+	}
+	meta.DeletedChats = slices.Delete(meta.DeletedChats, idx, idx+1)
+	return false
+}
+
 // parseSimplexTime parses a SimpleX timestamp string (RFC3339/ISO8601).
 func parseSimplexTime(ts string) time.Time {
 	t, err := time.Parse(time.RFC3339, ts)
@@ -691,24 +998,44 @@ func parseSimplexTime(ts string) time.Time {
 	return t
 }
 
-// uploadFilePartToMatrix reads a local file and uploads it to Matrix, updating the ConvertedMessagePart in place.
-func uploadFilePartToMatrix(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, part *bridgev2.ConvertedMessagePart, filePath string) error {
-	data, err := os.ReadFile(filePath)
+// uploadFilePartToMatrix streams a local file to Matrix, updating the ConvertedMessagePart in place.
+// Files larger than maxSize (if positive) are rejected with errFileTooLarge.
+func uploadFilePartToMatrix(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, part *bridgev2.ConvertedMessagePart, filePath string, maxSize int64) error {
+	stat, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("read file: %w", err)
+		return fmt.Errorf("stat file: %w", err)
 	}
-
 	fileName := filepath.Base(filePath)
 	if part.Content != nil && part.Content.Body != "" {
 		fileName = part.Content.Body
 	}
+	if maxSize > 0 && stat.Size() > maxSize {
+		return fmt.Errorf("%w: %s is %s, limit is %s", errFileTooLarge, fileName, formatFileSize(stat.Size()), formatFileSize(maxSize))
+	}
 
 	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
 	if mimeType == "" {
-		mimeType = http.DetectContentType(data)
+		mimeType, err = sniffMimeType(filePath)
+		if err != nil {
+			return fmt.Errorf("read file: %w", err)
+		}
+	}
+	// SimpleX voice messages are m4a, which is sniffed as video/mp4.
+	if part.Content != nil && part.Content.MSC3245Voice != nil && !isAudioMime(mimeType) {
+		mimeType = "audio/mp4"
 	}
 
-	uri, encFile, err := intent.UploadMedia(ctx, portal.MXID, data, fileName, mimeType)
+	uri, encFile, err := intent.UploadMediaStream(ctx, portal.MXID, stat.Size(), false, func(w io.Writer) (*bridgev2.FileStreamResult, error) {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %w", err)
+		}
+		defer f.Close()
+		if _, err = io.Copy(w, f); err != nil {
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+		return &bridgev2.FileStreamResult{FileName: fileName, MimeType: mimeType}, nil
+	})
 	if err != nil {
 		return fmt.Errorf("upload media: %w", err)
 	}
@@ -735,7 +1062,15 @@ func uploadFilePartToMatrix(ctx context.Context, portal *bridgev2.Portal, intent
 		mc.Info = &event.FileInfo{}
 	}
 	mc.Info.MimeType = mimeType
-	mc.Info.Size = len(data)
+	mc.Info.Size = int(stat.Size())
+	fillMediaInfo(ctx, mc.Info, filePath, mimeType)
+	if mc.MSC3245Voice != nil {
+		if mc.MSC1767Audio == nil {
+			mc.MSC1767Audio = &event.MSC1767Audio{}
+		}
+		mc.MSC1767Audio.Duration = mc.Info.Duration
+		mc.MSC1767Audio.Waveform = voiceWaveform(ctx, filePath)
+	}
 	mc.URL = uri
 	mc.File = encFile
 
@@ -756,18 +1091,12 @@ func (s *SimplexClient) resolveSimplexFilePath(filePath string) string {
 	if filepath.IsAbs(filePath) {
 		return filePath
 	}
-	filesFolder := s.Main.Config.FilesFolder
-	if filesFolder == "" {
-		// Default simplex-chat downloads location
-		home, _ := os.UserHomeDir()
-		filesFolder = filepath.Join(home, "Downloads")
-	}
-	return filepath.Join(filesFolder, filePath)
+	return filepath.Join(s.Main.getFilesFolder(), filePath)
 }
 
 func isAudioMime(mime string) bool {
 	switch mime {
-	case "audio/mpeg", "audio/ogg", "audio/aac", "audio/wav":
+	case "audio/mpeg", "audio/ogg", "audio/aac", "audio/wav", "audio/mp4", "audio/x-m4a", "audio/webm":
 		return true
 	}
 	return false
@@ -0,0 +1,80 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+)
+
+// getContactIncognitoName returns the name of the incognito profile used with
+// a contact, or an empty string if the contact sees the user's main profile.
+func (s *SimplexClient) getContactIncognitoName(ctx context.Context, contact *simplexclient.Contact) string {
+	if contact.ActiveConn == nil || contact.ActiveConn.CustomUserProfileID == nil {
+		return ""
+	}
+	profile, err := s.Client.GetContactIncognitoProfile(contact.ContactID)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Int64("contact_id", contact.ContactID).Msg("Failed to get incognito profile")
+		return ""
+	} else if profile == nil {
+		return ""
+	}
+	return profile.DisplayName
+}
+
+// incognitoMemberExtra returns the member event fields that set the user's
+// name in a room to their incognito name when they join it.
+func incognitoMemberExtra(incognitoName string) map[string]any {
+	if incognitoName == "" {
+		return nil
+	}
+	return map[string]any{"displayname": incognitoName}
+}
+
+// syncIncognitoDisplayname sets the user's double puppet name in a room to
+// the incognito name used in the chat, so that the Matrix room shows the same
+// identity as the other side sees.
+func (s *SimplexClient) syncIncognitoDisplayname(ctx context.Context, portal *bridgev2.Portal, incognitoName string) {
+	if incognitoName == "" || portal.MXID == "" {
+		return
+	}
+	intent := s.UserLogin.User.DoublePuppet(ctx)
+	if intent == nil {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	member, err := s.Main.Bridge.Matrix.GetMemberInfo(ctx, portal.MXID, intent.GetMXID())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to get own member info to set incognito name")
+		return
+	} else if member == nil || member.Membership != event.MembershipJoin || member.Displayname == incognitoName {
+		return
+	}
+	content := *member
+	content.Displayname = incognitoName
+	_, err = intent.SendState(ctx, portal.MXID, event.StateMember, intent.GetMXID().String(), &event.Content{Parsed: &content}, time.Time{})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to set incognito name in room")
+	}
+}
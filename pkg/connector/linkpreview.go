@@ -0,0 +1,328 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+)
+
+const (
+	linkPreviewTimeout = 8 * time.Second
+	// linkPreviewUserAgent is sent when fetching pages directly.
+	linkPreviewUserAgent = "mautrix-simplex link preview (+https://github.com/Tricked-dev/mautrix-simplex)"
+	// maxPreviewPageSize is how much of a page is read to find its metadata.
+	maxPreviewPageSize = 512 * 1024
+	// maxPreviewImageSize is the largest preview image that is downloaded.
+	maxPreviewImageSize = 4 * 1024 * 1024
+)
+
+var urlRe = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// extractFirstURL returns the first http/https URL found in text, or "".
+func extractFirstURL(text string) string {
+	return urlRe.FindString(text)
+}
+
+// getLinkPreview returns a SimpleX link preview for an outgoing message. It
+// uses the preview attached by the Matrix client if there is one, then the
+// homeserver's preview_url endpoint, and only fetches the page directly if
+// enabled in the config. Returns nil if there's no preview.
+func (s *SimplexClient) getLinkPreview(ctx context.Context, content *event.MessageEventContent, text string) *simplexclient.LinkPreview {
+	log := zerolog.Ctx(ctx)
+	// The client sent an empty list if the user removed the preview.
+	if content.BeeperLinkPreviews != nil {
+		for _, preview := range content.BeeperLinkPreviews {
+			if preview == nil || preview.Title == "" {
+				continue
+			}
+			uri := preview.MatchedURL
+			if uri == "" {
+				uri = preview.CanonicalURL
+			}
+			return s.convertMatrixLinkPreview(ctx, uri, &preview.LinkPreview, preview.ImageEncryption)
+		}
+		return nil
+	}
+
+	uri := extractFirstURL(text)
+	if uri == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, linkPreviewTimeout)
+	defer cancel()
+
+	if hsPreviews, ok := s.Main.Bridge.Matrix.(bridgev2.MatrixConnectorWithURLPreviews); ok && s.Main.Config.LinkPreviews.Homeserver {
+		preview, err := hsPreviews.GetURLPreview(ctx, uri)
+		if err != nil {
+			log.Debug().Err(err).Str("uri", uri).Msg("Failed to get link preview from homeserver")
+		} else if preview != nil && preview.Title != "" {
+			return s.convertMatrixLinkPreview(ctx, uri, preview, nil)
+		}
+	}
+
+	if !s.Main.Config.LinkPreviews.DirectFetch {
+		return nil
+	} else if !s.Main.Config.LinkPreviews.isDomainAllowed(uri) {
+		log.Debug().Str("uri", uri).Msg("Not fetching link preview for disallowed domain")
+		return nil
+	}
+	log.Debug().Str("uri", uri).Msg("Fetching link preview for outgoing message")
+	return fetchLinkPreview(ctx, s.Main.linkPreviewClient, &s.Main.Config.LinkPreviews, uri)
+}
+
+// convertMatrixLinkPreview converts a Matrix link preview, downloading its
+// image from Matrix media for the thumbnail.
+func (s *SimplexClient) convertMatrixLinkPreview(ctx context.Context, uri string, preview *event.LinkPreview, imageFile *event.EncryptedFileInfo) *simplexclient.LinkPreview {
+	converted := &simplexclient.LinkPreview{
+		URI:         uri,
+		Title:       preview.Title,
+		Description: preview.Description,
+	}
+	imageURL := preview.ImageURL
+	if imageFile != nil {
+		imageURL = imageFile.URL
+	}
+	if imageURL != "" && int(preview.ImageSize) <= maxPreviewImageSize {
+		data, err := s.Main.Bridge.Bot.DownloadMedia(ctx, imageURL, imageFile)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to download link preview image")
+		} else {
+			converted.Image = imageBytesThumbnailBase64(ctx, data)
+		}
+	}
+	return converted
+}
+
+// isDomainAllowed reports whether pages on the URL's domain may be fetched
+// directly. Domains match themselves and their subdomains, and the deny list
+// takes precedence over the allow list.
+func (c *LinkPreviewConfig) isDomainAllowed(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	matches := func(domain string) bool {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	for _, domain := range c.DeniedDomains {
+		if matches(domain) {
+			return false
+		}
+	}
+	if len(c.AllowedDomains) == 0 {
+		return true
+	}
+	for _, domain := range c.AllowedDomains {
+		if matches(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageMetadata is the preview metadata found in an HTML page.
+type pageMetadata struct {
+	Title       string
+	Description string
+	Image       string
+}
+
+// parsePageMetadata extracts OpenGraph metadata from an HTML page, falling
+// back to the <title> element and the description meta tag.
+func parsePageMetadata(r io.Reader) *pageMetadata {
+	var meta, fallback pageMetadata
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if meta.Title == "" {
+				meta.Title = fallback.Title
+			}
+			if meta.Description == "" {
+				meta.Description = fallback.Description
+			}
+			return &meta
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.DataAtom {
+			case atom.Title:
+				inTitle = fallback.Title == ""
+			case atom.Meta:
+				var property, name, content string
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "property":
+						property = strings.ToLower(attr.Val)
+					case "name":
+						name = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch {
+				case property == "og:title" || name == "og:title":
+					meta.Title = content
+				case property == "og:description" || name == "og:description":
+					meta.Description = content
+				case property == "og:image" || name == "og:image":
+					meta.Image = content
+				case name == "description":
+					fallback.Description = content
+				}
+			case atom.Body:
+				// All the metadata is in the head.
+				if meta.Title == "" {
+					meta.Title = fallback.Title
+				}
+				if meta.Description == "" {
+					meta.Description = fallback.Description
+				}
+				return &meta
+			}
+		case html.TextToken:
+			if inTitle {
+				fallback.Title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			inTitle = false
+		}
+	}
+}
+
+// fetchLinkPreview fetches the page at uri and extracts OG metadata plus a
+// thumbnail image. Returns nil if no useful data could be retrieved.
+func fetchLinkPreview(ctx context.Context, client *http.Client, cfg *LinkPreviewConfig, uri string) *simplexclient.LinkPreview {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", linkPreviewUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	ct := resp.Header.Get("Content-Type")
+	if !strings.Contains(ct, "text/html") && !strings.Contains(ct, "xhtml") {
+		return nil
+	}
+
+	page := parsePageMetadata(io.LimitReader(resp.Body, maxPreviewPageSize))
+	if page.Title == "" {
+		return nil
+	}
+	preview := &simplexclient.LinkPreview{
+		URI:         uri,
+		Title:       page.Title,
+		Description: page.Description,
+	}
+
+	// Fetch the og:image and generate a thumbnail from it.
+	if page.Image != "" {
+		imgURL, err := resp.Request.URL.Parse(page.Image)
+		if err == nil && (imgURL.Scheme == "http" || imgURL.Scheme == "https") && cfg.isDomainAllowed(imgURL.String()) {
+			preview.Image = fetchURLThumbnailBase64(ctx, client, imgURL.String())
+		}
+	}
+	return preview
+}
+
+// fetchURLThumbnailBase64 downloads an image URL and returns a base64
+// thumbnail of it.
+func fetchURLThumbnailBase64(ctx context.Context, client *http.Client, imgURL string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imgURL, nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("User-Agent", linkPreviewUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPreviewImageSize))
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+	return imageBytesThumbnailBase64(ctx, data)
+}
+
+// uploadLinkPreviewImage uploads the base64 preview image of an incoming
+// SimpleX link to Matrix and adds it to the Matrix link preview.
+func uploadLinkPreviewImage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, preview *event.BeeperLinkPreview, dataURI string) {
+	log := zerolog.Ctx(ctx)
+	data, err := decodeDataURI(dataURI)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to decode link preview image")
+		return
+	}
+	mimeType := http.DetectContentType(data)
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read link preview image")
+		return
+	}
+	preview.ImageURL, preview.ImageEncryption, err = intent.UploadMedia(ctx, portal.MXID, data, "preview"+mimeExtension(mimeType), mimeType)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to upload link preview image")
+		return
+	}
+	preview.ImageType = mimeType
+	preview.ImageSize = event.IntOrString(len(data))
+	preview.ImageWidth = event.IntOrString(cfg.Width)
+	preview.ImageHeight = event.IntOrString(cfg.Height)
+}
+
+// mimeExtension returns the file extension for a MIME type, e.g. ".jpg".
+func mimeExtension(mimeType string) string {
+	exts, _ := mime.ExtensionsByType(mimeType)
+	if len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
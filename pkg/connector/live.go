@@ -0,0 +1,185 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+)
+
+const (
+	// liveMessageFlag is the content field that makes a Matrix message or
+	// edit a live SimpleX message. Sending or editing without it finishes the
+	// live message.
+	liveMessageFlag = "fi.mau.simplex.live"
+	// liveEditInterval is the minimum time between bridged updates of an
+	// incoming live message. The final version is always bridged.
+	liveEditInterval = 2 * time.Second
+	// liveMessageExpiry is how long the state of a live message is kept after
+	// its last update, so that messages that are never finished, e.g. because
+	// the sender went offline, don't stay tracked forever.
+	liveMessageExpiry = 10 * time.Minute
+)
+
+// liveMessage is the throttling state of an incoming live message. Updates
+// are numbered so that a throttled update queued by a timer can't overwrite a
+// newer one that was queued before it.
+type liveMessage struct {
+	lastEdit   time.Time
+	lastUpdate time.Time
+	// seq is the sequence number of the latest update that was received.
+	seq uint64
+	// applied is the sequence number of the latest update that was converted
+	// to a Matrix edit.
+	applied    uint64
+	pending    *simplexclient.ChatItemUpdatedEvent
+	pendingSeq uint64
+	timer      *time.Timer
+}
+
+// isLiveMessage reports whether a Matrix message or edit has the live flag.
+func isLiveMessage(evt *event.Event) bool {
+	if evt == nil {
+		return false
+	}
+	raw := evt.Content.Raw
+	if newContent, ok := raw["m.new_content"].(map[string]any); ok {
+		raw = newContent
+	}
+	live, _ := raw[liveMessageFlag].(bool)
+	return live
+}
+
+// shouldBridgeLiveEdit reports whether an update of a chat item should be
+// bridged now, and returns its sequence number for claimLiveEdit. Updates of
+// live messages are coalesced so that at most one is bridged per
+// liveEditInterval, with the latest skipped one bridged when the interval
+// ends. The update that finishes a live message is always bridged.
+func (s *SimplexClient) shouldBridgeLiveEdit(ctx context.Context, data simplexclient.ChatItemUpdatedEvent) (uint64, bool) {
+	itemID := data.ChatItem.ChatItem.Meta.ItemID
+	isLive := data.ChatItem.ChatItem.Meta.ItemLive
+	now := time.Now()
+	s.liveMessagesLock.Lock()
+	defer s.liveMessagesLock.Unlock()
+	s.expireLiveMessagesLocked(now)
+	state, ok := s.liveMessages[itemID]
+	if isLive == nil || !*isLive {
+		if !ok {
+			return 0, true
+		}
+		// The state is kept until it expires so that a pending update
+		// that's already being queued is ignored.
+		if state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+		state.pending = nil
+		state.seq++
+		state.lastUpdate = now
+		return state.seq, true
+	}
+	if s.liveMessages == nil {
+		s.liveMessages = make(map[int64]*liveMessage)
+	}
+	if !ok {
+		state = &liveMessage{}
+		s.liveMessages[itemID] = state
+	}
+	state.seq++
+	state.lastUpdate = now
+	sinceLast := now.Sub(state.lastEdit)
+	if sinceLast >= liveEditInterval {
+		if state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+		state.lastEdit = now
+		state.pending = nil
+		return state.seq, true
+	}
+	state.pending = &data
+	state.pendingSeq = state.seq
+	if state.timer == nil {
+		state.timer = time.AfterFunc(liveEditInterval-sinceLast, func() {
+			s.flushLiveEdit(ctx, itemID)
+		})
+	}
+	return 0, false
+}
+
+// flushLiveEdit bridges the latest skipped update of a live message.
+func (s *SimplexClient) flushLiveEdit(ctx context.Context, itemID int64) {
+	s.liveMessagesLock.Lock()
+	state, ok := s.liveMessages[itemID]
+	if !ok || state.pending == nil {
+		s.liveMessagesLock.Unlock()
+		return
+	}
+	pending, seq := state.pending, state.pendingSeq
+	state.pending = nil
+	state.timer = nil
+	state.lastEdit = time.Now()
+	s.liveMessagesLock.Unlock()
+	s.queueChatItemEdit(ctx, *pending, seq)
+}
+
+// claimLiveEdit is called when an update is converted to a Matrix edit. It
+// reports false if a newer update of the live message was already bridged.
+// Updates without a sequence number aren't of live messages.
+func (s *SimplexClient) claimLiveEdit(itemID int64, seq uint64) bool {
+	if seq == 0 {
+		return true
+	}
+	s.liveMessagesLock.Lock()
+	defer s.liveMessagesLock.Unlock()
+	state, ok := s.liveMessages[itemID]
+	if !ok {
+		return true
+	} else if seq <= state.applied {
+		return false
+	}
+	state.applied = seq
+	return true
+}
+
+// expireLiveMessagesLocked drops the state of live messages that haven't
+// been updated for liveMessageExpiry. liveMessagesLock must be held.
+func (s *SimplexClient) expireLiveMessagesLocked(now time.Time) {
+	for itemID, state := range s.liveMessages {
+		if now.Sub(state.lastUpdate) < liveMessageExpiry {
+			continue
+		}
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		delete(s.liveMessages, itemID)
+	}
+}
+
+// forgetLiveMessage drops the throttling state of a live message.
+func (s *SimplexClient) forgetLiveMessage(itemID int64) {
+	s.liveMessagesLock.Lock()
+	defer s.liveMessagesLock.Unlock()
+	if state, ok := s.liveMessages[itemID]; ok && state.timer != nil {
+		state.timer.Stop()
+	}
+	delete(s.liveMessages, itemID)
+}
@@ -19,14 +19,16 @@ package connector
 import (
 	"context"
 	"fmt"
-	"net"
-	"os/exec"
-	"strconv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 	"maunium.net/go/mautrix/bridgev2/status"
+	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
 	"go.mau.fi/mautrix-simplex/pkg/simplexid"
@@ -125,12 +127,15 @@ func (w *WebSocketLogin) SubmitUserInput(ctx context.Context, input map[string]s
 type ManagedLogin struct {
 	User *bridgev2.User
 	Main *SimplexConnector
+
+	dbPath string
 }
 
 var _ bridgev2.LoginProcessUserInput = (*ManagedLogin)(nil)
 
 const (
 	LoginStepManagedDBPath = "fi.mau.simplex.login.managed_db_path"
+	LoginStepManagedDBKey  = "fi.mau.simplex.login.managed_db_key"
 )
 
 func (m *ManagedLogin) Cancel() {}
@@ -153,48 +158,98 @@ func (m *ManagedLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
 }
 
 func (m *ManagedLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	if m.dbPath != "" {
+		passphrase := input["passphrase"]
+		if passphrase == "" {
+			return nil, fmt.Errorf("passphrase is required")
+		}
+		return m.startProcess(ctx, passphrase, true)
+	}
 	dbPath, ok := input["db_path"]
 	if !ok || dbPath == "" {
 		return nil, fmt.Errorf("db_path is required")
 	}
+	return m.submitDBPath(ctx, dbPath)
+}
 
+// submitDBPath starts the process for the given database path, or asks for
+// the passphrase first if the database is encrypted and no key file is set.
+func (m *ManagedLogin) submitDBPath(ctx context.Context, dbPath string) (*bridgev2.LoginStep, error) {
+	m.dbPath = dbPath
+	if isDatabaseEncrypted(dbPath) {
+		keyFromFile, err := m.Main.readDBKeyFile()
+		if err != nil {
+			return nil, err
+		}
+		if keyFromFile == "" {
+			return &bridgev2.LoginStep{
+				Type:         bridgev2.LoginStepTypeUserInput,
+				StepID:       LoginStepManagedDBKey,
+				Instructions: "The SimpleX database is encrypted. Enter the database passphrase.",
+				UserInputParams: &bridgev2.LoginUserInputParams{
+					Fields: []bridgev2.LoginInputDataField{
+						{
+							Type: bridgev2.LoginInputFieldTypePassword,
+							ID:   "passphrase",
+							Name: "Database passphrase",
+						},
+					},
+				},
+			}, nil
+		}
+		return m.startProcess(ctx, keyFromFile, false)
+	}
+	return m.startProcess(ctx, "", false)
+}
+
+// startProcess starts the managed simplex-chat process and creates the user
+// login. If storeKey is true, the passphrase is saved (encrypted) in the login
+// metadata so the process can be restarted later.
+func (m *ManagedLogin) startProcess(ctx context.Context, dbKey string, storeKey bool) (*bridgev2.LoginStep, error) {
+	dbPath := m.dbPath
 	log := zerolog.Ctx(ctx)
 
-	// Find a free port
+	meta := &simplexid.UserLoginMetadata{
+		DBPath:  dbPath,
+		Managed: true,
+	}
+	if storeKey {
+		encryptedKey, err := m.Main.encryptDBKey(dbKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt database passphrase: %w", err)
+		}
+		meta.EncryptedDBKey = encryptedKey
+	}
+
 	port, err := findFreePort()
 	if err != nil {
 		return nil, fmt.Errorf("failed to find free port: %w", err)
 	}
-	wsURL := fmt.Sprintf("ws://localhost:%d", port)
-
 	log.Info().Str("db_path", dbPath).Int("port", port).Msg("Starting managed simplex-chat process")
 
-	// Start simplex-chat
-	cmd := exec.CommandContext(ctx, "simplex-chat", "-p", strconv.Itoa(port), "-d", dbPath)
-	if err := cmd.Start(); err != nil {
+	// The process is supervised independently of the login request context,
+	// so it keeps running after this request returns.
+	proc := m.Main.newSimplexProcess(dbPath, dbKey, port, m.Main.Bridge.Log)
+	if err = proc.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start simplex-chat: %w", err)
 	}
-
-	// Give it a moment to start up, then connect
-	// (A real implementation would poll with retries)
-	simplexLog := log.With().Str("component", "simplexclient").Logger()
-	var client *simplexclient.Client
-	for attempts := 0; attempts < 10; attempts++ {
-		client, err = simplexclient.New(ctx, wsURL, simplexLog)
-		if err == nil {
-			break
-		}
-		log.Debug().Err(err).Int("attempt", attempts+1).Msg("Waiting for simplex-chat to start")
+	if err = proc.WaitReady(ctx, managedStartTimeout); err != nil {
+		proc.Stop()
+		return nil, err
 	}
+	wsURL := proc.WSURL()
+	meta.WSUrl = wsURL
+
+	client, err := simplexclient.New(ctx, wsURL, log.With().Str("component", "simplexclient").Logger())
 	if err != nil {
-		cmd.Process.Kill()
-		return nil, fmt.Errorf("simplex-chat failed to become ready: %w", err)
+		proc.Stop()
+		return nil, fmt.Errorf("failed to connect to simplex-chat: %w", err)
 	}
 	defer client.Close()
 
 	user, err := client.GetActiveUser()
 	if err != nil {
-		cmd.Process.Kill()
+		proc.Stop()
 		return nil, fmt.Errorf("failed to get active user: %w", err)
 	}
 
@@ -205,21 +260,19 @@ func (m *ManagedLogin) SubmitUserInput(ctx context.Context, input map[string]str
 		RemoteProfile: status.RemoteProfile{
 			Name: user.Profile.DisplayName,
 		},
-		Metadata: &simplexid.UserLoginMetadata{
-			WSUrl:   wsURL,
-			DBPath:  dbPath,
-			Managed: true,
-		},
+		Metadata: meta,
 	}, &bridgev2.NewLoginParams{
 		DeleteOnConflict: true,
 	})
 	if err != nil {
-		cmd.Process.Kill()
+		proc.Stop()
 		return nil, fmt.Errorf("failed to create user login: %w", err)
 	}
 
-	// The actual managed process lifecycle will be handled during Connect()
-	go ul.Client.(*SimplexClient).Connect(m.Main.Bridge.BackgroundCtx)
+	// Hand the running process over to the client so Connect doesn't spawn another one.
+	sc := ul.Client.(*SimplexClient)
+	sc.process = proc
+	go sc.Connect(m.Main.Bridge.BackgroundCtx)
 
 	return &bridgev2.LoginStep{
 		Type:         bridgev2.LoginStepTypeComplete,
@@ -232,12 +285,166 @@ func (m *ManagedLogin) SubmitUserInput(ctx context.Context, input map[string]str
 	}, nil
 }
 
-// findFreePort finds an available TCP port.
-func findFreePort() (int, error) {
-	l, err := net.Listen("tcp", ":0")
+// --- ArchiveLogin ---
+
+// ArchiveLogin handles login by importing a chat archive exported from the
+// SimpleX mobile or desktop app into a new bridge-managed database.
+type ArchiveLogin struct {
+	ManagedLogin
+
+	imported bool
+	// dataDir is the data directory of the imported archive, removed if the
+	// login fails or is cancelled.
+	dataDir string
+}
+
+var _ bridgev2.LoginProcessUserInput = (*ArchiveLogin)(nil)
+
+const (
+	LoginStepArchivePath = "fi.mau.simplex.login.archive_path"
+)
+
+func (a *ArchiveLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepArchivePath,
+		Instructions: "Export your chat database from the SimpleX app (Settings → Database → Export database), then upload the zip archive to Matrix and enter its mxc:// URI.",
+		UserInputParams: &bridgev2.LoginUserInputParams{
+			Fields: []bridgev2.LoginInputDataField{
+				{
+					Type: bridgev2.LoginInputFieldTypeToken,
+					ID:   "archive",
+					Name: "Archive mxc:// URI",
+				},
+			},
+		},
+	}, nil
+}
+
+// Cancel removes the imported archive if the login wasn't completed.
+func (a *ArchiveLogin) Cancel() {
+	a.removeDataDir()
+}
+
+// removeDataDir removes the data directory of an imported archive that isn't
+// used by a login.
+func (a *ArchiveLogin) removeDataDir() {
+	if a.dataDir == "" {
+		return
+	}
+	if err := os.RemoveAll(a.dataDir); err != nil {
+		a.Main.Bridge.Log.Warn().Err(err).Str("data_dir", a.dataDir).Msg("Failed to remove data directory of unfinished import")
+	}
+	a.dataDir = ""
+}
+
+func (a *ArchiveLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	step, err := a.submitUserInput(ctx, input)
 	if err != nil {
-		return 0, err
+		// Failed logins can't be continued, so the imported database is
+		// no longer needed.
+		a.removeDataDir()
+	} else if step.Type == bridgev2.LoginStepTypeComplete {
+		a.dataDir = ""
+	}
+	return step, err
+}
+
+func (a *ArchiveLogin) submitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	if a.imported {
+		return a.ManagedLogin.SubmitUserInput(ctx, input)
+	}
+	archive, ok := input["archive"]
+	if !ok || archive == "" {
+		return nil, fmt.Errorf("archive is required")
+	} else if !strings.HasPrefix(archive, "mxc://") {
+		// Reading paths on the bridge host would let any user import any
+		// file the bridge can read.
+		return nil, fmt.Errorf("archive must be an mxc:// URI of an uploaded file")
+	}
+	dbPath, err := a.importArchive(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	a.imported = true
+	a.dataDir = filepath.Dir(dbPath)
+	return a.submitDBPath(ctx, dbPath)
+}
+
+// importArchive downloads the archive from the given mxc:// URI and unpacks
+// it into a new per-login data directory using simplex-chat's /_db import.
+// It returns the database path prefix.
+func (a *ArchiveLogin) importArchive(ctx context.Context, archive string) (dbPath string, err error) {
+	log := zerolog.Ctx(ctx)
+	dataDir, err := os.MkdirTemp(a.Main.getDataDirectory(), "login-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			if rmErr := os.RemoveAll(dataDir); rmErr != nil {
+				log.Warn().Err(rmErr).Str("data_dir", dataDir).Msg("Failed to remove data directory of failed import")
+			}
+		}
+	}()
+	dbPath = filepath.Join(dataDir, simplexDBPrefix)
+
+	archivePath := filepath.Join(dataDir, "import.zip")
+	err = a.Main.Bridge.Bot.DownloadMediaToFile(ctx, id.ContentURIString(archive), nil, false, func(f *os.File) error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return writeFileFrom(archivePath, f)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to download archive: %w", err)
+	}
+	defer os.Remove(archivePath)
+
+	port, err := findFreePort()
+	if err != nil {
+		return "", fmt.Errorf("failed to find free port: %w", err)
+	}
+	log.Info().Str("data_dir", dataDir).Msg("Importing SimpleX chat archive")
+
+	// The archive replaces the database, so the process only needs a
+	// placeholder profile to get past the first-run prompt.
+	proc := a.Main.newSimplexProcess(dbPath, "", port, a.Main.Bridge.Log)
+	proc.InitialProfile = "SimpleX import"
+	if err = proc.Start(); err != nil {
+		return "", fmt.Errorf("failed to start simplex-chat: %w", err)
+	}
+	defer proc.Stop()
+	if err = proc.WaitReady(ctx, managedStartTimeout); err != nil {
+		return "", err
+	}
+	client, err := simplexclient.New(ctx, proc.WSURL(), log.With().Str("component", "simplexclient").Logger())
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to simplex-chat: %w", err)
+	}
+	defer client.Close()
+	if err = client.StopChat(); err != nil {
+		return "", fmt.Errorf("failed to stop chat before import: %w", err)
+	}
+	archiveErrors, err := client.ImportArchive(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to import archive: %w", err)
+	}
+	if len(archiveErrors) > 0 {
+		log.Warn().Strs("archive_errors", archiveErrors).Msg("Chat archive imported with errors")
+	}
+	return dbPath, nil
+}
+
+// writeFileFrom creates the file at path and copies r into it.
+func writeFileFrom(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
 	}
-	defer l.Close()
-	return l.Addr().(*net.TCPAddr).Port, nil
+	return f.Close()
 }
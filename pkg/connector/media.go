@@ -0,0 +1,252 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	// thumbnailMaxDimension is the largest width or height of thumbnails.
+	// A larger but compressed image gives a better preview than a tiny sharp one.
+	thumbnailMaxDimension = 256
+	// thumbnailMinDimension is the smallest size thumbnails are shrunk to
+	// when they don't fit the size budget.
+	thumbnailMinDimension = 32
+	// thumbnailMaxBase64Len is the size budget of base64 thumbnails. SimpleX
+	// has a ~16KB message size limit and the thumbnail is embedded inside the
+	// JSON payload, so leave room for the rest of the message.
+	thumbnailMaxBase64Len = 10 * 1024
+	// maxDecodePixels protects against decompression bombs.
+	maxDecodePixels = 50 * 1000 * 1000
+)
+
+// thumbnailQualities are the JPEG qualities tried in order until the
+// thumbnail fits the size budget.
+var thumbnailQualities = []int{75, 60, 45, 30, 20, 10}
+
+// thumbnailBase64 scales an image down and encodes it as a JPEG data URI that
+// fits SimpleX's size budget, lowering the quality and then the size until it
+// does. Returns an empty string if it can't be made small enough.
+func thumbnailBase64(img image.Image) string {
+	var buf bytes.Buffer
+	for maxDim := thumbnailMaxDimension; maxDim >= thumbnailMinDimension; maxDim /= 2 {
+		scaled := scaleImage(img, maxDim)
+		for _, quality := range thumbnailQualities {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: quality}); err != nil {
+				return ""
+			}
+			encoded := "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+			if len(encoded) <= thumbnailMaxBase64Len {
+				return encoded
+			}
+		}
+	}
+	return ""
+}
+
+// scaleImage scales an image to fit within maxDim×maxDim, keeping the aspect
+// ratio. Images that are already small enough are only converted to RGBA.
+func scaleImage(img image.Image, maxDim int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > maxDim || h > maxDim {
+		if w >= h {
+			w, h = maxDim, max(1, h*maxDim/w)
+		} else {
+			w, h = max(1, w*maxDim/h), maxDim
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// JPEG has no alpha, so draw on white instead of black.
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// decodeImage decodes a JPEG, PNG, GIF or WebP image, refusing images with
+// an unreasonable number of pixels.
+func decodeImage(r io.ReadSeeker) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, fmt.Errorf("image too large to decode (%dx%d)", cfg.Width, cfg.Height)
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// imageThumbnailBase64 generates a thumbnail for an image file. Returns an
+// empty string on failure.
+func imageThumbnailBase64(ctx context.Context, filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	img, err := decodeImage(f)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("Failed to decode image for thumbnail")
+		return ""
+	}
+	return thumbnailBase64(img)
+}
+
+// imageBytesThumbnailBase64 generates a thumbnail from encoded image data.
+// Returns an empty string on failure.
+func imageBytesThumbnailBase64(ctx context.Context, data []byte) string {
+	img, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("Failed to decode image for thumbnail")
+		return ""
+	}
+	return thumbnailBase64(img)
+}
+
+// videoThumbnailBase64 extracts the first frame of a video with ffmpeg and
+// generates a thumbnail from it. Returns an empty string if ffmpeg isn't
+// installed or fails.
+func videoThumbnailBase64(ctx context.Context, filePath string) string {
+	log := zerolog.Ctx(ctx)
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		log.Debug().Msg("ffmpeg not found, not generating video thumbnail")
+		return ""
+	}
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-loglevel", "error",
+		"-i", filePath,
+		"-frames:v", "1",
+		"-f", "image2pipe",
+		"-c:v", "png",
+		"-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	frame, err := cmd.Output()
+	if err != nil {
+		log.Warn().Err(err).Str("output", stderr.String()).Msg("Failed to extract video frame for thumbnail")
+		return ""
+	}
+	return imageBytesThumbnailBase64(ctx, frame)
+}
+
+// mediaMetadata is the size and duration of a media file.
+type mediaMetadata struct {
+	Width    int
+	Height   int
+	Duration int // milliseconds
+}
+
+// probeMedia reads the dimensions and duration of a video or audio file with
+// ffprobe. Returns nil if ffprobe isn't installed or fails.
+func probeMedia(ctx context.Context, filePath string) *mediaMetadata {
+	log := zerolog.Ctx(ctx)
+	ffprobePath, err := exec.LookPath("ffprobe")
+	if err != nil {
+		log.Debug().Msg("ffprobe not found, not reading media metadata")
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, ffprobePath,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_entries", "format=duration:stream=codec_type,width,height",
+		filePath,
+	).Output()
+	if err != nil {
+		log.Warn().Err(err).Msg("ffprobe failed")
+		return nil
+	}
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err = json.Unmarshal(out, &probe); err != nil {
+		log.Warn().Err(err).Msg("Failed to parse ffprobe output")
+		return nil
+	}
+	var meta mediaMetadata
+	if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		meta.Duration = int(seconds * 1000)
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" && stream.Width > 0 {
+			meta.Width, meta.Height = stream.Width, stream.Height
+			break
+		}
+	}
+	return &meta
+}
+
+// fillMediaInfo fills in the dimensions and duration of a file bridged to
+// Matrix. Fields that are already set are kept.
+func fillMediaInfo(ctx context.Context, info *event.FileInfo, filePath, mimeType string) {
+	switch {
+	case isImageMime(mimeType):
+		f, err := os.Open(filePath)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		if cfg, _, err := image.DecodeConfig(f); err == nil {
+			info.Width, info.Height = cfg.Width, cfg.Height
+		}
+	case isVideoMime(mimeType), isAudioMime(mimeType):
+		if info.Duration > 0 && (info.Width > 0 || !isVideoMime(mimeType)) {
+			return
+		}
+		meta := probeMedia(ctx, filePath)
+		if meta == nil {
+			return
+		}
+		if info.Duration == 0 {
+			info.Duration = meta.Duration
+		}
+		if info.Width == 0 {
+			info.Width, info.Height = meta.Width, meta.Height
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+)
+
+// moderatedMessageRemove is the removal of a message by a group moderator.
+// bridgev2 can't attach a reason to redactions, so the redaction is sent in
+// PreHandle and the regular removal handling then finds nothing to remove.
+type moderatedMessageRemove struct {
+	*simplevent.MessageRemove
+	Source *bridgev2.UserLogin
+	Reason string
+}
+
+var _ bridgev2.RemotePreHandler = (*moderatedMessageRemove)(nil)
+
+func (evt *moderatedMessageRemove) PreHandle(ctx context.Context, portal *bridgev2.Portal) {
+	log := zerolog.Ctx(ctx)
+	parts, err := portal.Bridge.DB.Message.GetAllPartsByID(ctx, portal.Receiver, evt.TargetMessage)
+	if err != nil {
+		log.Err(err).Msg("Failed to get moderated message")
+		return
+	} else if len(parts) == 0 {
+		return
+	}
+	intent, ok := portal.GetIntentFor(ctx, evt.Sender, evt.Source, bridgev2.RemoteEventMessageRemove)
+	if !ok {
+		return
+	}
+	for _, part := range parts {
+		if part.HasFakeMXID() {
+			continue
+		}
+		_, err = intent.SendMessage(ctx, portal.MXID, event.EventRedaction, &event.Content{
+			Parsed: &event.RedactionEventContent{
+				Redacts: part.MXID,
+				Reason:  evt.Reason,
+			},
+		}, &bridgev2.MatrixSendExtra{Timestamp: evt.Timestamp, MessageMeta: part})
+		if err != nil {
+			log.Err(err).Stringer("part_mxid", part.MXID).Msg("Failed to redact moderated message part")
+			// Let the regular removal handling retry without a reason.
+			return
+		}
+	}
+	if err = portal.Bridge.DB.Message.DeleteAllParts(ctx, portal.Receiver, evt.TargetMessage); err != nil {
+		log.Err(err).Msg("Failed to delete moderated message from database")
+	}
+}
+
+// getModerator returns the moderator who deleted a chat item, or nil if it
+// wasn't deleted by a moderator.
+func getModerator(deletion simplexclient.ChatItemDeletion) *simplexclient.GroupMember {
+	for _, aci := range []*simplexclient.AChatItem{deletion.ToItem, deletion.DeletedChatItem} {
+		if aci == nil {
+			continue
+		}
+		if deleted := aci.ChatItem.Meta.ItemDeleted; deleted != nil && deleted.Type == "moderated" && deleted.ByGroup != nil {
+			return deleted.ByGroup
+		}
+	}
+	return nil
+}
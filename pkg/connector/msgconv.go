@@ -17,9 +17,11 @@
 package connector
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
+	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/event"
 
 	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
@@ -93,6 +95,80 @@ func MatrixToSimplexMsgContent(content *event.MessageEventContent) simplexclient
 	}
 }
 
+// MatrixEditToSimplexMsgContent converts the new content of a Matrix edit,
+// keeping the type and attachments of the original SimpleX content and only
+// replacing its text or caption. Links keep their preview as long as the URL
+// is still in the text.
+func MatrixEditToSimplexMsgContent(original json.RawMessage, content *event.MessageEventContent) simplexclient.MsgContent {
+	edited := MatrixToSimplexMsgContent(content)
+	var orig simplexclient.MsgContent
+	if len(original) == 0 || json.Unmarshal(original, &orig) != nil {
+		return edited
+	}
+	switch orig.Type {
+	case "image", "video", "voice", "file":
+		switch content.MsgType {
+		case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+			orig.Text = content.GetCaption()
+		default:
+			orig.Text = edited.Text
+		}
+		return orig
+	case "link":
+		if orig.Preview != nil && strings.Contains(edited.Text, orig.Preview.URI) {
+			orig.Text = edited.Text
+			return orig
+		}
+	}
+	return edited
+}
+
+// prependToContent adds text before the body of a message, converting it to
+// HTML if necessary. The body of media messages becomes a caption.
+func prependToContent(content *event.MessageEventContent, body, html string) {
+	switch content.MsgType {
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		if content.FileName == "" {
+			content.FileName = content.Body
+			content.Body = ""
+		}
+	}
+	if content.Format != event.FormatHTML {
+		content.Format = event.FormatHTML
+		content.FormattedBody = strings.ReplaceAll(escapeHTML(content.Body), "\n", "<br>")
+	}
+	content.FormattedBody = html + content.FormattedBody
+	content.Body = body + content.Body
+}
+
+// appendToContent adds text to the end of a message's body and formatted body.
+// For media, the text becomes part of the caption.
+func appendToContent(content *event.MessageEventContent, body, html string) {
+	switch content.MsgType {
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		if content.FileName == "" {
+			content.FileName = content.Body
+			content.Body = ""
+		}
+	}
+	if content.Format != event.FormatHTML {
+		content.Format = event.FormatHTML
+		content.FormattedBody = strings.ReplaceAll(escapeHTML(content.Body), "\n", "<br>")
+	}
+	content.FormattedBody += html
+	content.Body += body
+}
+
+// firstMessagePart returns the first m.room.message part of a message.
+func firstMessagePart(cm *bridgev2.ConvertedMessage) *bridgev2.ConvertedMessagePart {
+	for _, part := range cm.Parts {
+		if part.Type == event.EventMessage {
+			return part
+		}
+	}
+	return nil
+}
+
 // escapeHTML escapes special HTML characters.
 func escapeHTML(s string) string {
 	s = strings.ReplaceAll(s, "&", "&amp;")
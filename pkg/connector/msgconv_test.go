@@ -0,0 +1,121 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+)
+
+func TestMatrixEditToSimplexMsgContent(t *testing.T) {
+	thumbnail := "data:image/jpg;base64,AAAA"
+	duration := 12
+	preview := &simplexclient.LinkPreview{URI: "https://example.com", Title: "Example"}
+	tests := []struct {
+		name     string
+		original any
+		content  *event.MessageEventContent
+		want     simplexclient.MsgContent
+	}{
+		{
+			name:     "text",
+			original: simplexclient.MsgContent{Type: "text", Text: "hello"},
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "hello world"},
+			want:     simplexclient.MsgContent{Type: "text", Text: "hello world"},
+		},
+		{
+			name:     "image caption edited as text",
+			original: simplexclient.MsgContent{Type: "image", Text: "old caption", Image: &thumbnail},
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "new caption"},
+			want:     simplexclient.MsgContent{Type: "image", Text: "new caption", Image: &thumbnail},
+		},
+		{
+			name:     "image caption edited as image",
+			original: simplexclient.MsgContent{Type: "image", Text: "old caption", Image: &thumbnail},
+			content:  &event.MessageEventContent{MsgType: event.MsgImage, Body: "new caption", FileName: "photo.jpg"},
+			want:     simplexclient.MsgContent{Type: "image", Text: "new caption", Image: &thumbnail},
+		},
+		{
+			name:     "image caption removed",
+			original: simplexclient.MsgContent{Type: "image", Text: "old caption", Image: &thumbnail},
+			content:  &event.MessageEventContent{MsgType: event.MsgImage, Body: "photo.jpg", FileName: "photo.jpg"},
+			want:     simplexclient.MsgContent{Type: "image", Text: "", Image: &thumbnail},
+		},
+		{
+			name:     "voice keeps duration",
+			original: simplexclient.MsgContent{Type: "voice", Text: "", Duration: &duration},
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "transcript"},
+			want:     simplexclient.MsgContent{Type: "voice", Text: "transcript", Duration: &duration},
+		},
+		{
+			name:     "file caption",
+			original: simplexclient.MsgContent{Type: "file", Text: ""},
+			content:  &event.MessageEventContent{MsgType: event.MsgFile, Body: "the report", FileName: "report.pdf"},
+			want:     simplexclient.MsgContent{Type: "file", Text: "the report"},
+		},
+		{
+			name:     "link keeps preview",
+			original: simplexclient.MsgContent{Type: "link", Text: "see https://example.com", Preview: preview},
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "look at https://example.com"},
+			want:     simplexclient.MsgContent{Type: "link", Text: "look at https://example.com", Preview: preview},
+		},
+		{
+			name:     "link preview dropped with URL",
+			original: simplexclient.MsgContent{Type: "link", Text: "see https://example.com", Preview: preview},
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "never mind"},
+			want:     simplexclient.MsgContent{Type: "text", Text: "never mind"},
+		},
+		{
+			name:     "link without preview",
+			original: simplexclient.MsgContent{Type: "link", Text: "see https://example.com"},
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "see https://example.com too"},
+			want:     simplexclient.MsgContent{Type: "text", Text: "see https://example.com too"},
+		},
+		{
+			name:     "no original",
+			original: nil,
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "edited"},
+			want:     simplexclient.MsgContent{Type: "text", Text: "edited"},
+		},
+		{
+			name:     "invalid original",
+			original: json.RawMessage(`"not an object"`),
+			content:  &event.MessageEventContent{MsgType: event.MsgText, Body: "edited"},
+			want:     simplexclient.MsgContent{Type: "text", Text: "edited"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original json.RawMessage
+			if tt.original != nil {
+				var err error
+				if original, err = json.Marshal(tt.original); err != nil {
+					t.Fatalf("failed to marshal original: %v", err)
+				}
+			}
+			got := MatrixEditToSimplexMsgContent(original, tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MatrixEditToSimplexMsgContent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
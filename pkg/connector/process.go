@@ -0,0 +1,361 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+)
+
+const (
+	// processStopTimeout is how long to wait for simplex-chat to exit after
+	// an interrupt before it is killed.
+	processStopTimeout = 10 * time.Second
+	// processHealthInterval is how often the supervisor probes the WebSocket.
+	processHealthInterval = 30 * time.Second
+	// processHealthFailures is how many consecutive failed probes cause a restart.
+	processHealthFailures = 3
+	// processStableAfter is how long the process must stay up for the restart
+	// backoff to be reset.
+	processStableAfter = time.Minute
+)
+
+// simplexProcess supervises a bridge-managed simplex-chat process. It restarts
+// the process with exponential backoff if it crashes or stops answering on its
+// WebSocket port, and forwards its output into the bridge log.
+type simplexProcess struct {
+	Binary      string
+	DBPath      string
+	DBKey       string
+	Port        int
+	FilesFolder string
+	TempFolder  string
+	// InitialProfile is written to stdin as the display name if simplex-chat
+	// prompts for one because the database has no user profile yet.
+	InitialProfile string
+
+	log zerolog.Logger
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	running bool
+}
+
+// simplexDBPrefix is the database file prefix used for bridge-created data
+// directories, matching the default of the SimpleX apps.
+const simplexDBPrefix = "simplex_v1"
+
+// newSimplexProcess creates a supervisor for a simplex-chat process using the
+// bridge config and the given database path, passphrase and port.
+func (s *SimplexConnector) newSimplexProcess(dbPath, dbKey string, port int, log zerolog.Logger) *simplexProcess {
+	binary := s.Config.SimplexBinary
+	if binary == "" {
+		binary = "simplex-chat"
+	}
+	filesFolder := s.getFilesFolder()
+	return &simplexProcess{
+		Binary:      binary,
+		DBPath:      dbPath,
+		DBKey:       dbKey,
+		Port:        port,
+		FilesFolder: filesFolder,
+		TempFolder:  getTempFolder(filesFolder),
+		log:         log.With().Str("component", "simplex-chat").Int("port", port).Logger(),
+	}
+}
+
+// WSURL returns the WebSocket URL the process listens on.
+func (p *simplexProcess) WSURL() string {
+	return fmt.Sprintf("ws://localhost:%d", p.Port)
+}
+
+func (p *simplexProcess) args() []string {
+	args := []string{"-p", strconv.Itoa(p.Port), "-d", p.DBPath}
+	if p.FilesFolder != "" {
+		args = append(args, "--files-folder", p.FilesFolder)
+	}
+	if p.TempFolder != "" {
+		args = append(args, "--temp-folder", p.TempFolder)
+	}
+	if p.DBKey != "" {
+		args = append(args, "--key", p.DBKey)
+	}
+	return args
+}
+
+// Start launches the process and the supervisor loop. It is a no-op if the
+// supervisor is already running.
+func (p *simplexProcess) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		return nil
+	}
+	for _, dir := range []string{p.FilesFolder, p.TempFolder} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	if err := p.spawnLocked(); err != nil {
+		return err
+	}
+	p.running = true
+	p.stopCh = make(chan struct{})
+	p.doneCh = make(chan struct{})
+	go p.supervise()
+	return nil
+}
+
+func (p *simplexProcess) spawnLocked() error {
+	cmd := exec.Command(p.Binary, p.args()...)
+	if p.InitialProfile != "" {
+		cmd.Stdin = strings.NewReader(p.InitialProfile + "\n")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stderr pipe: %w", err)
+	}
+	p.log.Info().Str("binary", p.Binary).Str("db_path", p.DBPath).Bool("encrypted", p.DBKey != "").Msg("Starting simplex-chat process")
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", p.Binary, err)
+	}
+	go p.pipeLog(stdout, zerolog.DebugLevel)
+	go p.pipeLog(stderr, zerolog.WarnLevel)
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		p.log.Info().Err(err).Msg("simplex-chat process exited")
+		close(exited)
+	}()
+	p.cmd = cmd
+	p.exited = exited
+	return nil
+}
+
+// pipeLog copies each line of output from the process into the bridge log.
+func (p *simplexProcess) pipeLog(r io.Reader, level zerolog.Level) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		p.log.WithLevel(level).Str("output", scanner.Text()).Msg("simplex-chat output")
+	}
+}
+
+// supervise restarts the process when it exits or stops answering health checks.
+func (p *simplexProcess) supervise() {
+	defer close(p.doneCh)
+	retryCount := 0
+	for {
+		p.mu.Lock()
+		exited := p.exited
+		startedAt := time.Now()
+		p.mu.Unlock()
+
+		if !p.watch(exited) {
+			return
+		}
+		if time.Since(startedAt) > processStableAfter {
+			retryCount = 0
+		}
+		retryIn := 2 << retryCount
+		if retryIn > 150 {
+			retryIn = 150
+		}
+		p.log.Warn().Int("retry_in_seconds", retryIn).Msg("simplex-chat stopped unexpectedly, restarting")
+		select {
+		case <-time.After(time.Duration(retryIn) * time.Second):
+		case <-p.stopCh:
+			return
+		}
+		retryCount++
+
+		p.mu.Lock()
+		err := p.spawnLocked()
+		p.mu.Unlock()
+		if err != nil {
+			p.log.Err(err).Msg("Failed to restart simplex-chat")
+			// Wait out another backoff period before the next attempt.
+			p.mu.Lock()
+			closed := make(chan struct{})
+			close(closed)
+			p.exited = closed
+			p.mu.Unlock()
+		}
+	}
+}
+
+// watch blocks until the process exits (returns true) or the supervisor is
+// stopped (returns false). A process that repeatedly fails health checks is
+// killed so it gets restarted.
+func (p *simplexProcess) watch(exited chan struct{}) bool {
+	ticker := time.NewTicker(processHealthInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-p.stopCh:
+			return false
+		case <-exited:
+			return true
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := simplexclient.Probe(ctx, p.WSURL())
+			cancel()
+			if err == nil {
+				failures = 0
+				continue
+			}
+			failures++
+			p.log.Warn().Err(err).Int("failures", failures).Msg("simplex-chat health check failed")
+			if failures >= processHealthFailures {
+				p.log.Error().Msg("simplex-chat is unresponsive, killing process")
+				p.mu.Lock()
+				if p.cmd != nil && p.cmd.Process != nil {
+					_ = p.cmd.Process.Kill()
+				}
+				p.mu.Unlock()
+				failures = 0
+			}
+		}
+	}
+}
+
+// SetDBKey changes the passphrase used for future restarts of the process.
+func (p *simplexProcess) SetDBKey(key string) {
+	p.mu.Lock()
+	p.DBKey = key
+	p.mu.Unlock()
+}
+
+// WaitReady polls the WebSocket port until simplex-chat accepts connections.
+func (p *simplexProcess) WaitReady(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		exited := p.exited
+		p.mu.Unlock()
+		probeCtx, probeCancel := context.WithTimeout(ctx, 2*time.Second)
+		err := simplexclient.Probe(probeCtx, p.WSURL())
+		probeCancel()
+		if err == nil {
+			return nil
+		}
+		p.log.Debug().Err(err).Msg("Waiting for simplex-chat to start")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("simplex-chat did not become ready: %w", err)
+		case <-exited:
+			return fmt.Errorf("simplex-chat exited before becoming ready (wrong database passphrase?)")
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops the supervisor and gracefully shuts down the process, killing it
+// if it doesn't exit within processStopTimeout.
+func (p *simplexProcess) Stop() {
+	p.mu.Lock()
+	if !p.running {
+		p.mu.Unlock()
+		return
+	}
+	p.running = false
+	close(p.stopCh)
+	p.mu.Unlock()
+
+	<-p.doneCh
+	p.mu.Lock()
+	cmd, exited := p.cmd, p.exited
+	p.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	select {
+	case <-exited:
+		return
+	default:
+	}
+	p.log.Info().Msg("Stopping simplex-chat process")
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(processStopTimeout):
+		p.log.Warn().Msg("simplex-chat didn't exit in time, killing it")
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// portFromWSURL extracts the port from a stored WebSocket URL, returning 0 if
+// there is none.
+func portFromWSURL(wsURL string) int {
+	parsed, err := url.Parse(wsURL)
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(parsed.Port())
+	return port
+}
+
+// findFreePort finds an available TCP port.
+func findFreePort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// isPortFree checks whether a TCP port can currently be bound.
+func isPortFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
@@ -0,0 +1,178 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/variationselector"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+// mapReaction converts a Matrix reaction to one of the emoji SimpleX
+// supports, using the configured map for other emoji.
+func (c *SimplexConfig) mapReaction(emoji string) (string, bool) {
+	if supported, ok := normalizeEmojiForSimplex(emoji); ok {
+		return supported, true
+	}
+	supported, ok := c.reactionMap[variationselector.Remove(emoji)]
+	return supported, ok
+}
+
+// allowedReactions returns the reactions that can be bridged, in the form
+// Matrix clients send them.
+func (c *SimplexConfig) allowedReactions() []string {
+	allowed := []string{downloadReaction}
+	for emoji := range simplexSupportedEmojis {
+		allowed = append(allowed, variationselector.FullyQualify(emoji))
+	}
+	for emoji := range c.Reactions.Map {
+		allowed = append(allowed, variationselector.FullyQualify(emoji))
+	}
+	slices.Sort(allowed)
+	return slices.Compact(allowed)
+}
+
+// unsupportedReactionError is the error returned for reactions that SimpleX
+// doesn't support and that aren't bridged as text.
+func unsupportedReactionError(emoji string) error {
+	return bridgev2.WrapErrorInStatus(fmt.Errorf("SimpleX doesn't support %s reactions, only 👍👎😀😂😢❤🚀✅", emoji)).
+		WithIsCertain(true).
+		WithErrorAsMessage().
+		WithSendNotice(true).
+		WithErrorReason(event.MessageStatusUnsupported)
+}
+
+// sendReactionAsText sends a "reacted with" reply to a message instead of a
+// reaction that SimpleX doesn't support.
+func (s *SimplexClient) sendReactionAsText(ctx context.Context, portal *bridgev2.Portal, itemID int64, emoji string) (*database.Reaction, error) {
+	chatType, chatID, err := simplexid.ParsePortalID(portal.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse portal ID: %w", err)
+	}
+	sent, err := s.Client.SendMessages(chatType, chatID, []simplexclient.ComposedMessage{{
+		QuotedItemID: &itemID,
+		Mentions:     map[string]int64{},
+		MsgContent:   simplexclient.MsgContent{Type: "text", Text: "reacted with " + emoji},
+	}}, false)
+	if err != nil {
+		return nil, bridgev2.WrapErrorInStatus(err).WithSendNotice(true)
+	} else if len(sent) == 0 {
+		return nil, fmt.Errorf("no chat items returned after send")
+	}
+	fallbackItemID := sent[0].ChatItem.Meta.ItemID
+	// Don't bridge the echo of the reply back to Matrix.
+	(&bridgev2.MatrixMessage{
+		MatrixEventBase: bridgev2.MatrixEventBase[*event.MessageEventContent]{Portal: portal},
+	}).AddPendingToIgnore(networkid.TransactionID(simplexid.MakeMessageID(fallbackItemID)))
+	return &database.Reaction{
+		Metadata: &simplexid.ReactionMetadata{FallbackItemID: fallbackItemID},
+	}, nil
+}
+
+// removeSimplexReaction removes a bridged reaction from SimpleX, deleting the
+// "reacted with" reply if it was sent as text.
+func (s *SimplexClient) removeSimplexReaction(ctx context.Context, portal *bridgev2.Portal, reaction *database.Reaction) error {
+	chatType, chatID, err := simplexid.ParsePortalID(portal.ID)
+	if err != nil {
+		return fmt.Errorf("failed to parse portal ID: %w", err)
+	}
+	if meta, ok := reaction.Metadata.(*simplexid.ReactionMetadata); ok && meta.FallbackItemID != 0 {
+		zerolog.Ctx(ctx).Debug().Int64("fallback_item_id", meta.FallbackItemID).Msg("Deleting text reaction")
+		return s.Client.DeleteChatItem(chatType, chatID, meta.FallbackItemID, simplexclient.DeleteModeBroadcast)
+	}
+	itemID, err := simplexid.ParseMessageID(reaction.MessageID)
+	if err != nil {
+		return fmt.Errorf("failed to parse message ID: %w", err)
+	}
+	emoji, ok := normalizeEmojiForSimplex(reaction.Emoji)
+	if !ok {
+		// The reaction was never sent to SimpleX, e.g. a download reaction.
+		return nil
+	}
+	return s.Client.ReactToChatItem(chatType, chatID, itemID, emoji, false)
+}
+
+// resolveGroupReactionSender finds who added or removed a group reaction
+// whose event doesn't say, by comparing the members that reacted according
+// to the reaction members API with the bridged reactions.
+func (s *SimplexClient) resolveGroupReactionSender(ctx context.Context, reaction simplexclient.ACIReaction, added bool) (bridgev2.EventSender, bool) {
+	log := zerolog.Ctx(ctx)
+	groupInfo := reaction.ChatInfo.GroupInfo
+	if groupInfo == nil {
+		return bridgev2.EventSender{}, false
+	}
+	userID, _ := simplexid.ParseUserLoginID(s.UserLogin.ID)
+	itemID := reaction.ChatReaction.ChatItem.Meta.ItemID
+	emoji := reaction.ChatReaction.Reaction.Emoji
+	members, err := s.Client.GetReactionMembers(userID, groupInfo.GroupID, itemID, emoji)
+	if err != nil {
+		log.Err(err).Msg("Failed to get reaction members")
+		return bridgev2.EventSender{}, false
+	}
+	bridged, err := s.Main.Bridge.DB.Reaction.GetAllToMessage(ctx, s.UserLogin.ID, simplexid.MakeMessageID(itemID))
+	if err != nil {
+		log.Err(err).Msg("Failed to get bridged reactions")
+		return bridgev2.EventSender{}, false
+	}
+	bridgedSenders := make(map[networkid.UserID]struct{})
+	for _, r := range bridged {
+		if r.EmojiID == networkid.EmojiID(emoji) || (r.EmojiID == "" && r.Emoji == emoji) {
+			bridgedSenders[r.SenderID] = struct{}{}
+		}
+	}
+	ownSender := bridgev2.EventSender{IsFromMe: true, Sender: simplexid.MakeUserID(userID)}
+	reacted := make(map[networkid.UserID]bridgev2.EventSender, len(members))
+	var newest *simplexclient.MemberReaction
+	for i, member := range members {
+		sender := s.makeEventSenderFromMember(&member.GroupMember)
+		if member.GroupMember.MemberID == groupInfo.Membership.MemberID {
+			sender = ownSender
+		}
+		reacted[sender.Sender] = sender
+		if _, ok := bridgedSenders[sender.Sender]; added && !ok && (newest == nil || member.ReactionTs > newest.ReactionTs) {
+			newest = &members[i]
+		}
+	}
+	if added {
+		if newest == nil {
+			return bridgev2.EventSender{}, false
+		}
+		if newest.GroupMember.MemberID == groupInfo.Membership.MemberID {
+			return ownSender, true
+		}
+		return s.makeEventSenderFromMember(&newest.GroupMember), true
+	}
+	for senderID := range bridgedSenders {
+		if _, ok := reacted[senderID]; !ok {
+			if senderID == ownSender.Sender {
+				return ownSender, true
+			}
+			return bridgev2.EventSender{Sender: senderID}, true
+		}
+	}
+	return bridgev2.EventSender{}, false
+}
@@ -0,0 +1,132 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSimplexConfigPostProcess(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       SimplexConfig
+		wantErr      bool
+		wantFallback string
+	}{
+		{
+			name:         "defaults",
+			config:       SimplexConfig{DisplaynameTemplate: "{{.DisplayName}}"},
+			wantFallback: ReactionFallbackReject,
+		},
+		{
+			name:         "text fallback",
+			config:       SimplexConfig{Reactions: ReactionConfig{Fallback: ReactionFallbackText}},
+			wantFallback: ReactionFallbackText,
+		},
+		{
+			name:    "invalid fallback",
+			config:  SimplexConfig{Reactions: ReactionConfig{Fallback: "ignore"}},
+			wantErr: true,
+		},
+		{
+			name:         "valid map",
+			config:       SimplexConfig{Reactions: ReactionConfig{Map: map[string]string{"🔥": "🚀", "♥️": "❤️"}}},
+			wantFallback: ReactionFallbackReject,
+		},
+		{
+			name:    "map to unsupported emoji",
+			config:  SimplexConfig{Reactions: ReactionConfig{Map: map[string]string{"🔥": "🎉"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid displayname template",
+			config:  SimplexConfig{DisplaynameTemplate: "{{.DisplayName"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.PostProcess()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("PostProcess() succeeded, want error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("PostProcess() returned error: %v", err)
+			}
+			if tt.config.Reactions.Fallback != tt.wantFallback {
+				t.Errorf("fallback = %q, want %q", tt.config.Reactions.Fallback, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func TestMapReaction(t *testing.T) {
+	config := SimplexConfig{Reactions: ReactionConfig{Map: map[string]string{
+		"🔥":  "🚀",
+		"♥️": "❤️",
+		"☺️": "😀",
+		"👏":  "👍️",
+	}}}
+	if err := config.PostProcess(); err != nil {
+		t.Fatalf("PostProcess() returned error: %v", err)
+	}
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"👍", "👍", true},
+		{"👍️", "👍", true},
+		{"❤", "❤", true},
+		{"❤️", "❤", true},
+		{"✅", "✅", true},
+		{"🔥", "🚀", true},
+		{"♥", "❤", true},
+		{"♥️", "❤", true},
+		{"☺", "😀", true},
+		{"👏", "👍", true},
+		{"🎉", "", false},
+		{"👍🏽", "", false},
+		{"", "", false},
+		{"thumbs up", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := config.mapReaction(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("mapReaction(%q) = %q, %t, want %q, %t", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestAllowedReactions(t *testing.T) {
+	config := SimplexConfig{Reactions: ReactionConfig{Map: map[string]string{"🔥": "🚀"}}}
+	if err := config.PostProcess(); err != nil {
+		t.Fatalf("PostProcess() returned error: %v", err)
+	}
+	allowed := config.allowedReactions()
+	for _, emoji := range []string{downloadReaction, "👍", "❤️", "🔥", "🚀"} {
+		if !slices.Contains(allowed, emoji) {
+			t.Errorf("allowedReactions() doesn't contain %q", emoji)
+		}
+	}
+	if !slices.IsSorted(allowed) || len(slices.Compact(slices.Clone(allowed))) != len(allowed) {
+		t.Errorf("allowedReactions() isn't sorted and deduplicated: %q", allowed)
+	}
+}
@@ -0,0 +1,161 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+
+	"go.mau.fi/mautrix-simplex/pkg/simplexclient"
+	"go.mau.fi/mautrix-simplex/pkg/simplexid"
+)
+
+// maxQuoteSnippetLength is the maximum number of characters of the replied
+// message quoted in replies sent to SimpleX across chats.
+const maxQuoteSnippetLength = 100
+
+// addQuoteFallback renders the quoted message of a reply as a blockquote if
+// the quoted message isn't bridged, as a Matrix reply to it would be dropped.
+// batch contains the item IDs of a backfill batch, which can be replied to
+// even though they aren't in the database yet.
+func (s *SimplexClient) addQuoteFallback(ctx context.Context, portal *bridgev2.Portal, cm *bridgev2.ConvertedMessage, item *simplexclient.ChatItem, batch map[int64]struct{}) {
+	quote := item.QuotedItem
+	if quote == nil || item.Meta.ItemDeleted != nil {
+		return
+	}
+	if quote.ItemID != nil {
+		if _, ok := batch[*quote.ItemID]; ok {
+			return
+		}
+		target, err := s.Main.Bridge.DB.Message.GetFirstPartByID(ctx, portal.Receiver, simplexid.MakeMessageID(*quote.ItemID))
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get quoted message")
+		} else if target != nil {
+			return
+		}
+	}
+	cm.ReplyTo = nil
+	part := firstMessagePart(cm)
+	if part == nil {
+		return
+	}
+
+	author := s.getQuoteAuthorName(ctx, portal, quote.ChatDir)
+	body, html := SimplexFormattedToMatrix(quote.FormattedText)
+	if body == "" {
+		body = quote.Content.Text
+	}
+	if body == "" {
+		body = fmt.Sprintf("[%s]", quote.Content.Type)
+	}
+	if html == "" {
+		html = strings.ReplaceAll(escapeHTML(body), "\n", "<br>")
+	}
+
+	quoteLines := strings.Split(author+": "+body, "\n")
+	prependToContent(
+		part.Content,
+		"> "+strings.Join(quoteLines, "\n> ")+"\n\n",
+		fmt.Sprintf("<blockquote><strong>%s</strong>: %s</blockquote>", escapeHTML(author), html),
+	)
+}
+
+// getQuoteAuthorName returns the name of the sender of a quoted message.
+func (s *SimplexClient) getQuoteAuthorName(ctx context.Context, portal *bridgev2.Portal, dir *simplexclient.ChatItemDir) string {
+	if dir == nil {
+		return "Unknown"
+	}
+	switch dir.Type {
+	case "directSnd", "groupSnd", "localSnd", "localRcv":
+		if s.UserLogin.RemoteName != "" {
+			return s.UserLogin.RemoteName
+		}
+		return "You"
+	case "groupRcv":
+		if dir.GroupMember != nil {
+			if dir.GroupMember.Profile.DisplayName != "" {
+				return dir.GroupMember.Profile.DisplayName
+			}
+			return dir.GroupMember.LocalDisplayName
+		}
+	case "directRcv":
+		_, contactID, err := simplexid.ParsePortalID(portal.ID)
+		if err != nil {
+			break
+		}
+		ghost, err := s.Main.Bridge.GetExistingGhostByID(ctx, simplexid.MakeUserID(contactID))
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get ghost of quoted message sender")
+		} else if ghost != nil && ghost.Name != "" {
+			return ghost.Name
+		}
+		if portal.Name != "" {
+			return portal.Name
+		}
+	}
+	return "Unknown"
+}
+
+// makeCrossChatQuote returns a quote of a message in another chat, which
+// can't be replied to directly on SimpleX, to prepend to a message's text.
+// Messages in rooms of other logins aren't quoted, as their chat IDs refer to
+// another SimpleX account.
+func (s *SimplexClient) makeCrossChatQuote(ctx context.Context, replyTo *database.Message) string {
+	log := zerolog.Ctx(ctx)
+	if replyTo.Room.Receiver != s.UserLogin.ID {
+		log.Debug().Str("receiver", string(replyTo.Room.Receiver)).Msg("Not quoting reply to message of another login")
+		return ""
+	}
+	chatType, chatID, err := simplexid.ParsePortalID(replyTo.Room.ID)
+	if err != nil {
+		return ""
+	}
+	itemID, err := simplexid.ParseMessageID(replyTo.ID)
+	if err != nil {
+		return ""
+	}
+	item, err := s.Client.GetChatItem(chatType, chatID, itemID)
+	if err != nil {
+		log.Warn().Err(err).Int64("item_id", itemID).Msg("Failed to get replied message from other chat")
+		return ""
+	} else if item == nil {
+		return ""
+	}
+	text := parseMsgContent(item).Text
+	if text == "" {
+		text = item.Meta.ItemText
+	}
+	if runes := []rune(strings.Join(strings.Fields(text), " ")); len(runes) > maxQuoteSnippetLength {
+		text = string(runes[:maxQuoteSnippetLength]) + "…"
+	} else {
+		text = string(runes)
+	}
+	author := "Unknown"
+	if s.IsThisUser(ctx, replyTo.SenderID) {
+		author = s.UserLogin.RemoteName
+	} else if ghost, err := s.Main.Bridge.GetExistingGhostByID(ctx, replyTo.SenderID); err != nil {
+		log.Err(err).Msg("Failed to get ghost of replied message sender")
+	} else if ghost != nil && ghost.Name != "" {
+		author = ghost.Name
+	}
+	return fmt.Sprintf("> %s: %s\n\n", author, text)
+}
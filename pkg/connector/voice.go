@@ -0,0 +1,156 @@
+// mautrix-simplex - A Matrix-SimpleX puppeting bridge.
+// Copyright (C) 2024 Tricked
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// waveformSamples is the number of values in voice message waveforms.
+	waveformSamples = 64
+	// waveformMaxValue is the maximum waveform value per MSC1767.
+	waveformMaxValue = 1024
+	// waveformSampleRate is the sample rate audio is decoded at for
+	// computing waveforms. The peaks don't need any more precision.
+	waveformSampleRate = 1000
+)
+
+// isSimplexVoiceMime reports whether a MIME type is already the m4a/AAC
+// format used by SimpleX voice messages.
+func isSimplexVoiceMime(mimeType string) bool {
+	switch mimeType {
+	case "audio/mp4", "audio/x-m4a", "audio/aac":
+		return true
+	}
+	return false
+}
+
+// transcodeVoice converts a voice message to m4a/AAC with the configured
+// encoder command and returns the path of the converted file, next to the
+// input file.
+func (s *SimplexConnector) transcodeVoice(ctx context.Context, inputPath string) (string, error) {
+	args := strings.Fields(s.Config.VoiceEncoder)
+	if len(args) == 0 {
+		return "", fmt.Errorf("voice_encoder is not configured")
+	}
+	outputPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ".m4a"
+	if outputPath == inputPath {
+		outputPath = strings.TrimSuffix(inputPath, ".m4a") + "-converted.m4a"
+	}
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "{input}", inputPath)
+		args[i] = strings.ReplaceAll(arg, "{output}", outputPath)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(outputPath)
+		return "", fmt.Errorf("voice encoder failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	zerolog.Ctx(ctx).Debug().
+		Str("input", filepath.Base(inputPath)).
+		Str("output", filepath.Base(outputPath)).
+		Msg("Transcoded voice message")
+	return outputPath, nil
+}
+
+// voiceWaveform decodes an audio file with ffmpeg and returns its waveform
+// for MSC1767 audio events. Returns nil if ffmpeg isn't installed or fails.
+func voiceWaveform(ctx context.Context, filePath string) []int {
+	log := zerolog.Ctx(ctx)
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		log.Debug().Msg("ffmpeg not found, not generating waveform")
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, ffmpegPath,
+		"-loglevel", "error",
+		"-i", filePath,
+		"-ac", "1",
+		"-ar", fmt.Sprint(waveformSampleRate),
+		"-f", "s16le",
+		"-",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil
+	}
+	if err = cmd.Start(); err != nil {
+		log.Warn().Err(err).Msg("Failed to start ffmpeg for waveform")
+		return nil
+	}
+	var peaks []int
+	var sample int16
+	r := bufio.NewReader(stdout)
+	for {
+		if err = binary.Read(r, binary.LittleEndian, &sample); err != nil {
+			break
+		}
+		peaks = append(peaks, abs(int(sample)))
+	}
+	if waitErr := cmd.Wait(); waitErr != nil || (err != nil && !errors.Is(err, io.EOF)) {
+		log.Warn().AnErr("wait_error", waitErr).AnErr("read_error", err).Msg("Failed to decode audio for waveform")
+		return nil
+	}
+	return bucketWaveform(peaks)
+}
+
+// bucketWaveform reduces absolute sample values to waveformSamples peaks
+// scaled to 0-waveformMaxValue.
+func bucketWaveform(samples []int) []int {
+	if len(samples) == 0 {
+		return nil
+	}
+	waveform := make([]int, waveformSamples)
+	maxPeak := 0
+	for i := range waveform {
+		start := i * len(samples) / waveformSamples
+		end := max((i+1)*len(samples)/waveformSamples, start+1)
+		for _, v := range samples[start:min(end, len(samples))] {
+			waveform[i] = max(waveform[i], v)
+		}
+		maxPeak = max(maxPeak, waveform[i])
+	}
+	if maxPeak == 0 {
+		return waveform
+	}
+	for i, v := range waveform {
+		waveform[i] = v * waveformMaxValue / maxPeak
+	}
+	return waveform
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
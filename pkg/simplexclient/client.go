@@ -27,6 +27,11 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// maxMessageSize is the WebSocket read limit. Files are passed by path, so
+// messages only carry small base64 thumbnails and profile images, but chat and
+// contact lists can still add up to several megabytes.
+const maxMessageSize = 16 * 1024 * 1024
+
 // Client is a WebSocket client for the SimpleX Chat API
 type Client struct {
 	ws      *websocket.Conn
@@ -57,8 +62,7 @@ func New(ctx context.Context, wsURL string, log zerolog.Logger) (*Client, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial simplex-chat WebSocket at %s: %w", wsURL, err)
 	}
-	// Increase read limit to 100MB to handle large messages (e.g. images/files in base64)
-	ws.SetReadLimit(100 * 1024 * 1024)
+	ws.SetReadLimit(maxMessageSize)
 	c := &Client{
 		ws:       ws,
 		pending:  make(map[string]chan json.RawMessage),
@@ -70,6 +74,16 @@ func New(ctx context.Context, wsURL string, log zerolog.Logger) (*Client, error)
 	return c, nil
 }
 
+// Probe checks whether a simplex-chat instance is accepting WebSocket
+// connections at wsURL without starting a full client.
+func Probe(ctx context.Context, wsURL string) error {
+	ws, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		return err
+	}
+	return ws.Close(websocket.StatusNormalClosure, "probe done")
+}
+
 func (c *Client) Close() error {
 	return c.ws.Close(websocket.StatusNormalClosure, "bridge shutting down")
 }
@@ -115,7 +129,7 @@ func (c *Client) sendOneShotCmd(ctx context.Context, cmd string) (string, json.R
 	if err != nil {
 		return "", nil, fmt.Errorf("one-shot dial failed: %w", err)
 	}
-	ws.SetReadLimit(100 * 1024 * 1024)
+	ws.SetReadLimit(maxMessageSize)
 	defer ws.Close(websocket.StatusNormalClosure, "one-shot done")
 
 	id := c.corrID.Add(1)
@@ -20,6 +20,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // chatRef returns the text chat reference used in commands, e.g. "@42" or "#7"
@@ -27,6 +29,14 @@ func chatRef(chatType ChatType, chatID int64) string {
 	return fmt.Sprintf("%s%d", chatType, chatID)
 }
 
+// onOff returns the "on" or "off" value of a boolean command option
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
 // GetActiveUser retrieves the active user profile
 func (c *Client) GetActiveUser() (*User, error) {
 	respType, raw, err := c.sendCmd(`/u`)
@@ -86,6 +96,34 @@ func (c *Client) ListGroups(userID int64) ([]GroupInfo, error) {
 	return r.Groups, nil
 }
 
+// ListNoteFolders retrieves the local notes chats of the given user. It lists
+// every chat of the user with its preview, so the result should be cached.
+func (c *Client) ListNoteFolders(userID int64) ([]NoteFolder, error) {
+	cmd := fmt.Sprintf("/_get chats %d pcc=off", userID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if respType != "apiChats" {
+		return nil, fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r struct {
+		Chats []struct {
+			ChatInfo ChatInfo `json:"chatInfo"`
+		} `json:"chats"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse apiChats: %w", err)
+	}
+	var folders []NoteFolder
+	for _, chat := range r.Chats {
+		if chat.ChatInfo.Type == "local" && chat.ChatInfo.NoteFolder != nil {
+			folders = append(folders, *chat.ChatInfo.NoteFolder)
+		}
+	}
+	return folders, nil
+}
+
 // ListMembers retrieves members of a group
 func (c *Client) ListMembers(groupID int64) ([]GroupMember, error) {
 	cmd := fmt.Sprintf("/_members #%d", groupID)
@@ -141,14 +179,67 @@ func (c *Client) GetChat(chatType ChatType, chatID int64, pagination ChatPaginat
 	return &r.Chat, nil
 }
 
-// SendMessages sends messages to a contact or group
-func (c *Client) SendMessages(chatType ChatType, chatID int64, msgs []ComposedMessage) ([]AChatItem, error) {
+// GetChatItem retrieves a single chat item by ID. Returns nil if the item
+// doesn't exist.
+func (c *Client) GetChatItem(chatType ChatType, chatID, itemID int64) (*ChatItem, error) {
+	chat, err := c.GetChat(chatType, chatID, ChatPagination{
+		Type:   PaginationAround,
+		ItemID: itemID,
+		Count:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range chat.ChatItems {
+		if chat.ChatItems[i].Meta.ItemID == itemID {
+			return &chat.ChatItems[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetChatItemInfo retrieves the edit history of a chat item
+func (c *Client) GetChatItemInfo(chatType ChatType, chatID, itemID int64) (*ChatItemInfo, error) {
+	// Format: /_get item info @<chatId> <itemId>
+	cmd := fmt.Sprintf("/_get item info %s %d", chatRef(chatType, chatID), itemID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if respType == "chatCmdError" {
+		return nil, fmt.Errorf("simplex-chat item info error: %s", string(raw))
+	}
+	if respType != "chatItemInfo" {
+		return nil, fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r struct {
+		ChatItemInfo ChatItemInfo `json:"chatItemInfo"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse chatItemInfo: %w", err)
+	}
+	return &r.ChatItemInfo, nil
+}
+
+// sendMessagesCmd returns the command for sending messages. Notes are created
+// locally instead of sent, and can't be live.
+func sendMessagesCmd(chatType ChatType, chatID int64, msgsJSON []byte, live bool) string {
+	if chatType == ChatTypeLocal {
+		// Format: /_create *<id> json [<composedMessages>]
+		return fmt.Sprintf("/_create %s%d json %s", chatType, chatID, msgsJSON)
+	}
+	// Format: /_send @<id> live=<on|off> json [<composedMessages>]
+	return fmt.Sprintf("/_send %s%d live=%s json %s", chatType, chatID, onOff(live), msgsJSON)
+}
+
+// SendMessages sends messages to a contact, group or notes chat. Live messages keep
+// updating as they're edited until an edit finishes them.
+func (c *Client) SendMessages(chatType ChatType, chatID int64, msgs []ComposedMessage, live bool) ([]AChatItem, error) {
 	msgsJSON, err := json.Marshal(msgs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal messages: %w", err)
 	}
-	// Format: /_send @<id> live=off json [<composedMessages>]
-	cmd := fmt.Sprintf("/_send %s%d live=off json %s", chatType, chatID, msgsJSON)
+	cmd := sendMessagesCmd(chatType, chatID, msgsJSON, live)
 	c.log.Debug().Str("send_cmd_preview", cmd[:min(len(cmd), 400)]).Msg("SendMessages command")
 	respType, raw, err := c.sendCmd(cmd)
 	if err != nil {
@@ -166,6 +257,30 @@ func (c *Client) SendMessages(chatType ChatType, chatID int64, msgs []ComposedMe
 	return r.ChatItems, nil
 }
 
+// ForwardChatItems forwards chat items to another chat. Forwarded items are
+// marked as forwarded and their files are re-used instead of uploaded again.
+func (c *Client) ForwardChatItems(toType ChatType, toID int64, fromType ChatType, fromID int64, itemIDs []int64) ([]AChatItem, error) {
+	ids := make([]string, len(itemIDs))
+	for i, itemID := range itemIDs {
+		ids[i] = strconv.FormatInt(itemID, 10)
+	}
+	cmd := fmt.Sprintf("/_forward %s %s %s", chatRef(toType, toID), chatRef(fromType, fromID), strings.Join(ids, ","))
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if respType != "newChatItems" {
+		return nil, fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r struct {
+		ChatItems []AChatItem `json:"chatItems"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse newChatItems: %w", err)
+	}
+	return r.ChatItems, nil
+}
+
 // SendMessagesRetryOnce sends messages like SendMessages but reconnects and retries once on
 // connection loss. Use this for file/media sends where simplex-chat may drop the connection.
 func (c *Client) SendMessagesRetryOnce(ctx context.Context, chatType ChatType, chatID int64, msgs []ComposedMessage) ([]AChatItem, error) {
@@ -173,7 +288,7 @@ func (c *Client) SendMessagesRetryOnce(ctx context.Context, chatType ChatType, c
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal messages: %w", err)
 	}
-	cmd := fmt.Sprintf("/_send %s%d live=off json %s", chatType, chatID, msgsJSON)
+	cmd := sendMessagesCmd(chatType, chatID, msgsJSON, false)
 	c.log.Debug().Str("send_cmd_preview", cmd[:min(len(cmd), 400)]).Msg("SendMessagesRetryOnce command")
 	respType, raw, err := c.sendCmdRetryOnce(ctx, cmd)
 	if err != nil {
@@ -191,8 +306,9 @@ func (c *Client) SendMessagesRetryOnce(ctx context.Context, chatType ChatType, c
 	return r.ChatItems, nil
 }
 
-// UpdateChatItem edits a message
-func (c *Client) UpdateChatItem(chatType ChatType, chatID, itemID int64, content MsgContent) (*ChatItem, error) {
+// UpdateChatItem edits a message. Editing a live message with live set to
+// false finishes it.
+func (c *Client) UpdateChatItem(chatType ChatType, chatID, itemID int64, content MsgContent, live bool) (*ChatItem, error) {
 	updatedMsg := struct {
 		MsgContent MsgContent        `json:"msgContent"`
 		Mentions   map[string]string `json:"mentions"`
@@ -204,8 +320,10 @@ func (c *Client) UpdateChatItem(chatType ChatType, chatID, itemID int64, content
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal updated message: %w", err)
 	}
-	// Format: /_update item @<id> <itemId> live=off json<updatedMessage>
-	cmd := fmt.Sprintf("/_update item %s%d %d live=off json%s", chatType, chatID, itemID, updatedJSON)
+	// Format: /_update item @<id> <itemId> live=<on|off> json<updatedMessage>
+	// Notes can't be live.
+	live = live && chatType != ChatTypeLocal
+	cmd := fmt.Sprintf("/_update item %s%d %d live=%s json%s", chatType, chatID, itemID, onOff(live), updatedJSON)
 	respType, raw, err := c.sendCmd(cmd)
 	if err != nil {
 		return nil, err
@@ -249,6 +367,77 @@ func (c *Client) DeleteChatItem(chatType ChatType, chatID, itemID int64, mode De
 	return nil
 }
 
+// DeleteMemberChatItem deletes another member's message in a group for
+// everyone, which requires moderator rights in the group.
+func (c *Client) DeleteMemberChatItem(groupID, itemID int64) error {
+	cmd := fmt.Sprintf(`/_delete member item #%d %d`, groupID, itemID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if respType == "chatCmdError" {
+		return fmt.Errorf("simplex-chat delete error: %s", string(raw))
+	}
+	if respType != "chatItemsDeleted" {
+		return fmt.Errorf("unexpected response type: %s", respType)
+	}
+	return nil
+}
+
+// ClearChat deletes all messages in a chat locally, keeping the chat itself.
+func (c *Client) ClearChat(chatType ChatType, chatID int64) error {
+	// Format: /_clear chat @<chatId>
+	cmd := fmt.Sprintf("/_clear chat %s%d", chatType, chatID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if respType == "chatCmdError" {
+		return fmt.Errorf("simplex-chat clear error: %s", string(raw))
+	}
+	if respType != "chatCleared" {
+		return fmt.Errorf("unexpected response type: %s", respType)
+	}
+	return nil
+}
+
+// DeleteChat deletes a contact or group along with its messages. Contacts
+// are notified of the deletion. Groups can only be deleted by their owners or
+// after leaving them.
+func (c *Client) DeleteChat(chatType ChatType, chatID int64) error {
+	// Format: /_delete @<chatId>
+	cmd := fmt.Sprintf("/_delete %s%d", chatType, chatID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return err
+	}
+	switch respType {
+	case "contactDeleted", "groupDeletedUser", "contactConnectionDeleted":
+		return nil
+	case "chatCmdError":
+		return fmt.Errorf("simplex-chat delete error: %s", string(raw))
+	default:
+		return fmt.Errorf("unexpected response type: %s", respType)
+	}
+}
+
+// LeaveGroup leaves a group, notifying the other members.
+func (c *Client) LeaveGroup(groupID int64) error {
+	// Format: /_leave #<groupId>
+	cmd := fmt.Sprintf("/_leave #%d", groupID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if respType == "chatCmdError" {
+		return fmt.Errorf("simplex-chat leave error: %s", string(raw))
+	}
+	if respType != "leftMemberUser" {
+		return fmt.Errorf("unexpected response type: %s", respType)
+	}
+	return nil
+}
+
 // ReactToChatItem adds or removes a reaction
 func (c *Client) ReactToChatItem(chatType ChatType, chatID, itemID int64, emoji string, add bool) error {
 	addStr := "on"
@@ -268,24 +457,78 @@ func (c *Client) ReactToChatItem(chatType ChatType, chatID, itemID int64, emoji
 	return nil
 }
 
-// AcceptContact accepts an incoming contact request
-func (c *Client) AcceptContact(contactReqID int64) (*Contact, error) {
-	// Format: /_accept incognito=off <contactReqId>
-	cmd := fmt.Sprintf("/_accept incognito=off %d", contactReqID)
+// GetReactionMembers returns the group members who reacted to a chat item with an emoji
+func (c *Client) GetReactionMembers(userID, groupID, itemID int64, emoji string) ([]MemberReaction, error) {
+	reactionJSON, _ := json.Marshal(map[string]string{"type": "emoji", "emoji": emoji})
+	// Format: /_reaction members <userId> #<groupId> <itemId> <reactionJSON>
+	cmd := fmt.Sprintf("/_reaction members %d #%d %d %s", userID, groupID, itemID, reactionJSON)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if respType != "reactionMembers" {
+		return nil, fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r struct {
+		MemberReactions []MemberReaction `json:"memberReactions"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse reactionMembers: %w", err)
+	}
+	return r.MemberReactions, nil
+}
+
+// AcceptContact accepts an incoming contact request, optionally with a random
+// incognito profile. Requests to a business address create a business chat,
+// which is returned instead of a contact.
+func (c *Client) AcceptContact(contactReqID int64, incognito bool) (*Contact, *GroupInfo, error) {
+	// Format: /_accept incognito=<on|off> <contactReqId>
+	cmd := fmt.Sprintf("/_accept incognito=%s %d", onOff(incognito), contactReqID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch respType {
+	case "acceptingContactRequest":
+		var r struct {
+			Contact Contact `json:"contact"`
+		}
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse acceptingContactRequest: %w", err)
+		}
+		return &r.Contact, nil, nil
+	case "acceptingBusinessRequest":
+		var r struct {
+			GroupInfo GroupInfo `json:"groupInfo"`
+		}
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse acceptingBusinessRequest: %w", err)
+		}
+		return nil, &r.GroupInfo, nil
+	default:
+		return nil, nil, fmt.Errorf("unexpected response type: %s", respType)
+	}
+}
+
+// GetContactIncognitoProfile retrieves the incognito profile we use with a
+// contact. Returns nil if we use our main profile.
+func (c *Client) GetContactIncognitoProfile(contactID int64) (*Profile, error) {
+	// Format: /_info @<contactId>
+	cmd := fmt.Sprintf("/_info @%d", contactID)
 	respType, raw, err := c.sendCmd(cmd)
 	if err != nil {
 		return nil, err
 	}
-	if respType != "acceptingContactRequest" {
+	if respType != "contactInfo" {
 		return nil, fmt.Errorf("unexpected response type: %s", respType)
 	}
 	var r struct {
-		Contact Contact `json:"contact"`
+		CustomUserProfile *Profile `json:"customUserProfile"`
 	}
 	if err := json.Unmarshal(raw, &r); err != nil {
-		return nil, fmt.Errorf("failed to parse acceptingContactRequest: %w", err)
+		return nil, fmt.Errorf("failed to parse contactInfo: %w", err)
 	}
-	return &r.Contact, nil
+	return r.CustomUserProfile, nil
 }
 
 // CreateAddress creates a SimpleX address for the user
@@ -300,46 +543,176 @@ func (c *Client) CreateAddress(userID int64) (string, error) {
 		return "", fmt.Errorf("unexpected response type: %s", respType)
 	}
 	var r struct {
-		ConnLinkContact struct {
-			ConnShortLink string `json:"connShortLink"`
-			ConnFullLink  string `json:"connFullLink"`
-		} `json:"connLinkContact"`
+		ConnLinkContact CreatedLink `json:"connLinkContact"`
 	}
 	if err := json.Unmarshal(raw, &r); err != nil {
 		return "", fmt.Errorf("failed to parse userContactLinkCreated: %w", err)
 	}
-	if r.ConnLinkContact.ConnShortLink != "" {
-		return r.ConnLinkContact.ConnShortLink, nil
+	return r.ConnLinkContact.String(), nil
+}
+
+// GetAddressSettings retrieves the settings of the user's address.
+func (c *Client) GetAddressSettings(userID int64) (*AddressSettings, error) {
+	// Format: /_show_address <userId>
+	cmd := fmt.Sprintf("/_show_address %d", userID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if respType == "chatCmdError" {
+		return nil, fmt.Errorf("simplex-chat show address error: %s", string(raw))
+	}
+	if respType != "userContactLink" {
+		return nil, fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r struct {
+		ContactLink struct {
+			AddressSettings *AddressSettings `json:"addressSettings"`
+			// Older versions of simplex-chat only have auto-accept settings,
+			// which also contain the business address flag.
+			AutoAccept *struct {
+				BusinessAddress bool        `json:"businessAddress"`
+				AcceptIncognito bool        `json:"acceptIncognito"`
+				AutoReply       *MsgContent `json:"autoReply"`
+			} `json:"autoAccept"`
+		} `json:"contactLink"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse userContactLink: %w", err)
+	}
+	if r.ContactLink.AddressSettings != nil {
+		return r.ContactLink.AddressSettings, nil
+	}
+	settings := &AddressSettings{}
+	if aa := r.ContactLink.AutoAccept; aa != nil {
+		settings.BusinessAddress = aa.BusinessAddress
+		settings.AutoAccept = &AddressAutoAccept{AcceptIncognito: aa.AcceptIncognito}
+		settings.AutoReply = aa.AutoReply
 	}
-	return r.ConnLinkContact.ConnFullLink, nil
+	return settings, nil
 }
 
-// SetAddressAutoAccept configures auto-accept for contact requests
-func (c *Client) SetAddressAutoAccept(userID int64, autoAccept bool, autoReply *MsgContent) error {
-	var settingsJSON []byte
-	var err error
-	if autoAccept {
-		if autoReply != nil {
-			replyJSON, _ := json.Marshal(autoReply)
-			settingsJSON = []byte(fmt.Sprintf(`{"businessAddress":false,"autoAccept":{"acceptIncognito":false},"autoReply":%s}`, replyJSON))
-		} else {
-			settingsJSON = []byte(`{"businessAddress":false,"autoAccept":{"acceptIncognito":false}}`)
-		}
-	} else {
-		settingsJSON = []byte(`{"businessAddress":false}`)
+// SetAddressSettings configures the user's address. Requests to business
+// addresses are accepted as business chats instead of contacts.
+func (c *Client) SetAddressSettings(userID int64, settings AddressSettings) error {
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal address settings: %w", err)
 	}
 	// Format: /_address_settings <userId> <settingsJSON>
 	cmd := fmt.Sprintf("/_address_settings %d %s", userID, settingsJSON)
-	respType, _, err := c.sendCmd(cmd)
+	respType, raw, err := c.sendCmd(cmd)
 	if err != nil {
 		return err
 	}
+	if respType == "chatCmdError" {
+		return fmt.Errorf("simplex-chat address settings error: %s", string(raw))
+	}
 	if respType != "userContactLinkUpdated" {
 		return fmt.Errorf("unexpected response type: %s", respType)
 	}
 	return nil
 }
 
+// groupLinkResponse is the response to group link commands. Older versions
+// of simplex-chat return the link directly instead of in groupLink.
+type groupLinkResponse struct {
+	ConnReqContact  string      `json:"connReqContact"`
+	ConnLinkContact CreatedLink `json:"connLinkContact"`
+	GroupLink       struct {
+		ConnLinkContact CreatedLink `json:"connLinkContact"`
+	} `json:"groupLink"`
+}
+
+func (r *groupLinkResponse) link() string {
+	if link := r.GroupLink.ConnLinkContact.String(); link != "" {
+		return link
+	} else if link = r.ConnLinkContact.String(); link != "" {
+		return link
+	}
+	return r.ConnReqContact
+}
+
+// CreateGroupLink creates a link for joining a group. Members who join with
+// the link get the given role.
+func (c *Client) CreateGroupLink(groupID int64, role GroupMemberRole) (string, error) {
+	// Format: /_create link #<groupId> <role>
+	cmd := fmt.Sprintf("/_create link #%d %s", groupID, role)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return "", err
+	}
+	if respType == "chatCmdError" {
+		return "", fmt.Errorf("simplex-chat group link error: %s", string(raw))
+	}
+	if respType != "groupLinkCreated" {
+		return "", fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r groupLinkResponse
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return "", fmt.Errorf("failed to parse groupLinkCreated: %w", err)
+	}
+	return r.link(), nil
+}
+
+// GetGroupLink retrieves the link for joining a group
+func (c *Client) GetGroupLink(groupID int64) (string, error) {
+	// Format: /_get link #<groupId>
+	cmd := fmt.Sprintf("/_get link #%d", groupID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return "", err
+	}
+	if respType == "chatCmdError" {
+		return "", fmt.Errorf("simplex-chat group link error: %s", string(raw))
+	}
+	if respType != "groupLink" {
+		return "", fmt.Errorf("unexpected response type: %s", respType)
+	}
+	var r groupLinkResponse
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return "", fmt.Errorf("failed to parse groupLink: %w", err)
+	}
+	return r.link(), nil
+}
+
+// DeleteGroupLink deletes the link for joining a group
+func (c *Client) DeleteGroupLink(groupID int64) error {
+	// Format: /_delete link #<groupId>
+	cmd := fmt.Sprintf("/_delete link #%d", groupID)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if respType == "chatCmdError" {
+		return fmt.Errorf("simplex-chat group link error: %s", string(raw))
+	}
+	if respType != "groupLinkDeleted" {
+		return fmt.Errorf("unexpected response type: %s", respType)
+	}
+	return nil
+}
+
+// Connect connects to a SimpleX contact address, invitation or group link,
+// optionally with a random incognito profile. Joining a group completes
+// asynchronously with a userJoinedGroup event.
+func (c *Client) Connect(userID int64, link string, incognito bool) error {
+	// Format: /_connect <userId> incognito=<on|off> <link>
+	cmd := fmt.Sprintf("/_connect %d incognito=%s %s", userID, onOff(incognito), link)
+	respType, raw, err := c.sendCmd(cmd)
+	if err != nil {
+		return err
+	}
+	switch respType {
+	case "sentConfirmation", "sentInvitation":
+		return nil
+	case "chatCmdError":
+		return fmt.Errorf("simplex-chat connect error: %s", string(raw))
+	default:
+		return fmt.Errorf("unexpected response type: %s", respType)
+	}
+}
+
 // JoinGroup accepts a group invitation
 func (c *Client) JoinGroup(groupID int64) (*GroupInfo, error) {
 	// Format: /_join #<groupId>
@@ -396,3 +769,107 @@ func (c *Client) UpdateGroupProfile(groupID int64, profile GroupProfile) (*Group
 	}
 	return &r.ToGroup, nil
 }
+
+// StopChat stops the chat controller. Required before database operations
+// such as changing the encryption key or exporting.
+func (c *Client) StopChat() error {
+	respType, raw, err := c.sendCmd("/_stop")
+	if err != nil {
+		return err
+	}
+	if respType != "chatStopped" {
+		return fmt.Errorf("unexpected response type: %s (raw: %s)", respType, string(raw))
+	}
+	return nil
+}
+
+// StartChat starts the chat controller after it was stopped.
+func (c *Client) StartChat() error {
+	respType, raw, err := c.sendCmd("/_start")
+	if err != nil {
+		return err
+	}
+	if respType != "chatStarted" && respType != "chatRunning" {
+		return fmt.Errorf("unexpected response type: %s (raw: %s)", respType, string(raw))
+	}
+	return nil
+}
+
+// SetDBEncryption sets or changes the database encryption passphrase.
+// The chat must be stopped first. An empty currentKey means the database
+// is not encrypted yet.
+func (c *Client) SetDBEncryption(currentKey, newKey string) error {
+	cfgJSON, err := json.Marshal(struct {
+		CurrentKey string `json:"currentKey"`
+		NewKey     string `json:"newKey"`
+	}{currentKey, newKey})
+	if err != nil {
+		return fmt.Errorf("failed to marshal encryption config: %w", err)
+	}
+	// Format: /_db encryption <json>
+	respType, raw, err := c.sendCmd(fmt.Sprintf("/_db encryption %s", cfgJSON))
+	if err != nil {
+		return err
+	}
+	if respType != "cmdOk" {
+		return fmt.Errorf("unexpected response type: %s (raw: %s)", respType, string(raw))
+	}
+	return nil
+}
+
+// archiveConfig is the argument to the /_db import and /_db export commands.
+type archiveConfig struct {
+	ArchivePath        string `json:"archivePath"`
+	DisableCompression *bool  `json:"disableCompression,omitempty"`
+}
+
+// ImportArchive replaces the chat database with the contents of a chat archive
+// exported from a SimpleX app. The chat must be stopped first. Non-fatal
+// errors for individual files are returned as strings.
+func (c *Client) ImportArchive(archivePath string) ([]string, error) {
+	cfgJSON, err := json.Marshal(archiveConfig{ArchivePath: archivePath})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal archive config: %w", err)
+	}
+	// Format: /_db import <json>
+	respType, raw, err := c.sendCmd(fmt.Sprintf("/_db import %s", cfgJSON))
+	if err != nil {
+		return nil, err
+	}
+	if respType != "archiveImported" {
+		return nil, fmt.Errorf("unexpected response type: %s (raw: %s)", respType, string(raw))
+	}
+	return parseArchiveErrors(raw)
+}
+
+// ExportArchive exports the chat database and files into a zip archive that
+// can be imported into a SimpleX app. The chat must be stopped first.
+func (c *Client) ExportArchive(archivePath string) ([]string, error) {
+	cfgJSON, err := json.Marshal(archiveConfig{ArchivePath: archivePath})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal archive config: %w", err)
+	}
+	// Format: /_db export <json>
+	respType, raw, err := c.sendCmd(fmt.Sprintf("/_db export %s", cfgJSON))
+	if err != nil {
+		return nil, err
+	}
+	if respType != "archiveExported" && respType != "cmdOk" {
+		return nil, fmt.Errorf("unexpected response type: %s (raw: %s)", respType, string(raw))
+	}
+	return parseArchiveErrors(raw)
+}
+
+func parseArchiveErrors(raw json.RawMessage) ([]string, error) {
+	var r struct {
+		ArchiveErrors []json.RawMessage `json:"archiveErrors"`
+	}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse archive response: %w", err)
+	}
+	errs := make([]string, len(r.ArchiveErrors))
+	for i, e := range r.ArchiveErrors {
+		errs[i] = string(e)
+	}
+	return errs, nil
+}
@@ -18,12 +18,14 @@ package simplexclient
 
 import "encoding/json"
 
-// ChatType represents the type of chat (Direct or Group)
+// ChatType represents the type of chat (Direct, Group or Local)
 type ChatType string
 
 const (
 	ChatTypeDirect ChatType = "@"
 	ChatTypeGroup  ChatType = "#"
+	// ChatTypeLocal is the local notes ("note to self") chat
+	ChatTypeLocal ChatType = "*"
 )
 
 // ChatRef is a reference to a chat
@@ -78,6 +80,8 @@ type User struct {
 
 // Profile represents a user or contact profile
 type Profile struct {
+	// ProfileID is only set on local profiles.
+	ProfileID   int64   `json:"profileId,omitempty"`
 	DisplayName string  `json:"displayName"`
 	FullName    string  `json:"fullName"`
 	Image       *string `json:"image,omitempty"`
@@ -98,6 +102,16 @@ type Contact struct {
 	Profile          Profile `json:"profile"`
 	ContactUsed      bool    `json:"contactUsed"`
 	CreatedAt        string  `json:"createdAt"`
+	// ActiveConn is the connection used to talk to the contact.
+	ActiveConn *Connection `json:"activeConn,omitempty"`
+}
+
+// Connection represents a connection to a contact or group member
+type Connection struct {
+	ConnID int64 `json:"connId"`
+	// CustomUserProfileID is set if we use an incognito profile on the
+	// connection.
+	CustomUserProfileID *int64 `json:"customUserProfileId,omitempty"`
 }
 
 // GroupInfo represents a group
@@ -107,6 +121,18 @@ type GroupInfo struct {
 	GroupProfile     GroupProfile `json:"groupProfile"`
 	Membership       GroupMember  `json:"membership"`
 	CreatedAt        string       `json:"createdAt"`
+	// BusinessChat is set if the group is a business chat.
+	BusinessChat *BusinessChatInfo `json:"businessChat,omitempty"`
+}
+
+// BusinessChatInfo describes a business chat, a group between a business and
+// a customer where the business can add staff members
+type BusinessChatInfo struct {
+	// ChatType is "business" if we're the customer and "customer" if we're
+	// the business.
+	ChatType   string `json:"chatType"`
+	BusinessID string `json:"businessId"`
+	CustomerID string `json:"customerId"`
 }
 
 // GroupMember represents a group member
@@ -120,6 +146,14 @@ type GroupMember struct {
 	LocalDisplayName string          `json:"localDisplayName"`
 	Profile          Profile         `json:"memberProfile"`
 	ContactID        *int64          `json:"contactId,omitempty"`
+	// MemberContactProfileID is the ID of the member's main profile, which
+	// differs from the member profile if they joined incognito.
+	MemberContactProfileID int64 `json:"memberContactProfileId,omitempty"`
+}
+
+// Incognito reports whether the member uses an incognito profile in the group
+func (m *GroupMember) Incognito() bool {
+	return m.Profile.ProfileID != 0 && m.MemberContactProfileID != 0 && m.Profile.ProfileID != m.MemberContactProfileID
 }
 
 // ChatItemMeta contains metadata about a chat item
@@ -133,6 +167,21 @@ type ChatItemMeta struct {
 	ItemDeleted *ItemDeleted    `json:"itemDeleted,omitempty"`
 	ItemEdited  bool            `json:"itemEdited,omitempty"`
 	ItemLive    *bool           `json:"itemLive,omitempty"`
+	// Editable is whether simplex-chat allows editing the item, i.e. whether
+	// it was sent less than a day ago and isn't forwarded.
+	Editable bool `json:"editable"`
+	// ItemForwarded is set if the item was forwarded from another chat.
+	ItemForwarded *CIForwardedFrom `json:"itemForwarded,omitempty"`
+}
+
+// CIForwardedFrom describes where a forwarded chat item came from
+type CIForwardedFrom struct {
+	Type       string `json:"type"` // "unknown", "contact", "group"
+	ChatName   string `json:"chatName,omitempty"`
+	MsgDir     string `json:"msgDir,omitempty"` // "rcv", "snd"
+	ContactID  *int64 `json:"contactId,omitempty"`
+	GroupID    *int64 `json:"groupId,omitempty"`
+	ChatItemID *int64 `json:"chatItemId,omitempty"`
 }
 
 // ItemTimedData contains timed message data
@@ -143,13 +192,8 @@ type ItemTimedData struct {
 
 // ItemDeleted contains deletion info
 type ItemDeleted struct {
-	Type    string  `json:"type"`
-	ByGroup *Member `json:"byGroupMember,omitempty"`
-}
-
-// Member represents a minimal member reference
-type Member struct {
-	GroupMemberID int64 `json:"groupMemberId"`
+	Type    string       `json:"type"` // "deleted", "blocked", "blockedByAdmin", "moderated"
+	ByGroup *GroupMember `json:"byGroupMember,omitempty"`
 }
 
 // ChatItemContent represents the content of a message
@@ -248,6 +292,24 @@ func (f *CIFile) GetFilePath() string {
 	return ""
 }
 
+// CIFileStatus is the transfer status of a file attached to a chat item.
+type CIFileStatus struct {
+	Type        string `json:"type"`
+	RcvProgress int64  `json:"rcvProgress,omitempty"`
+	RcvTotal    int64  `json:"rcvTotal,omitempty"`
+	SndProgress int64  `json:"sndProgress,omitempty"`
+	SndTotal    int64  `json:"sndTotal,omitempty"`
+}
+
+// GetStatus parses the file transfer status, e.g. rcvTransfer or rcvError.
+func (f *CIFile) GetStatus() CIFileStatus {
+	var status CIFileStatus
+	if len(f.FileStatus) > 0 {
+		_ = json.Unmarshal(f.FileStatus, &status)
+	}
+	return status
+}
+
 // ChatItem represents a message
 type ChatItem struct {
 	ChatDir       ChatItemDir                     `json:"chatDir"`
@@ -279,8 +341,15 @@ type Format struct {
 
 // CIReactionCount represents an emoji reaction with count
 type CIReactionCount struct {
-	Reaction      MsgReaction `json:"reaction"`
-	ReactionCount int         `json:"reactionCount"`
+	Reaction     MsgReaction `json:"reaction"`
+	UserReacted  bool        `json:"userReacted"`
+	TotalReacted int         `json:"totalReacted"`
+}
+
+// MemberReaction represents a group member's reaction to a chat item
+type MemberReaction struct {
+	GroupMember GroupMember `json:"groupMember"`
+	ReactionTs  string      `json:"reactionTs"`
 }
 
 // MsgReaction represents a reaction
@@ -306,6 +375,19 @@ type CIGroupMemberMention struct {
 	LocalDisplayName string `json:"localDisplayName"`
 }
 
+// ChatItemInfo contains the edit history of a chat item
+type ChatItemInfo struct {
+	ItemVersions []ChatItemVersion `json:"itemVersions"`
+}
+
+// ChatItemVersion is one version of an edited chat item, newest first
+type ChatItemVersion struct {
+	ChatItemVersionID int64      `json:"chatItemVersionId"`
+	MsgContent        MsgContent `json:"msgContent"`
+	ItemVersionTs     string     `json:"itemVersionTs"`
+	CreatedAt         string     `json:"createdAt"`
+}
+
 // AChatItem wraps a chat item with its chat info
 type AChatItem struct {
 	ChatInfo ChatInfo `json:"chatInfo"`
@@ -314,15 +396,31 @@ type AChatItem struct {
 
 // ChatInfo contains info about a chat
 type ChatInfo struct {
-	Type      string     `json:"type"` // "direct", "group"
-	Contact   *Contact   `json:"contact,omitempty"`
-	GroupInfo *GroupInfo `json:"groupInfo,omitempty"`
+	Type       string      `json:"type"` // "direct", "group", "local"
+	Contact    *Contact    `json:"contact,omitempty"`
+	GroupInfo  *GroupInfo  `json:"groupInfo,omitempty"`
+	NoteFolder *NoteFolder `json:"noteFolder,omitempty"`
+}
+
+// NoteFolder is the local notes chat of a user
+type NoteFolder struct {
+	NoteFolderID int64  `json:"noteFolderId"`
+	UserID       int64  `json:"userId"`
+	CreatedAt    string `json:"createdAt"`
 }
 
 // AChat wraps chat items with chat info
 type AChat struct {
 	ChatInfo  ChatInfo   `json:"chatInfo"`
 	ChatItems []ChatItem `json:"chatItems"`
+	ChatStats ChatStats  `json:"chatStats"`
+}
+
+// ChatStats contains the unread state of a chat
+type ChatStats struct {
+	UnreadCount     int   `json:"unreadCount"`
+	MinUnreadItemID int64 `json:"minUnreadItemId"`
+	UnreadChat      bool  `json:"unreadChat"`
 }
 
 // ChatItemDeletion represents a deletion
@@ -355,6 +453,18 @@ type UserContactRequest struct {
 	Profile          Profile `json:"profile"`
 }
 
+// AddressSettings are the settings of a user's address
+type AddressSettings struct {
+	BusinessAddress bool               `json:"businessAddress"`
+	AutoAccept      *AddressAutoAccept `json:"autoAccept,omitempty"`
+	AutoReply       *MsgContent        `json:"autoReply,omitempty"`
+}
+
+// AddressAutoAccept configures automatic acceptance of contact requests
+type AddressAutoAccept struct {
+	AcceptIncognito bool `json:"acceptIncognito"`
+}
+
 // ComposedMessage is a message to be sent
 type ComposedMessage struct {
 	FileSource   *CryptoFile      `json:"fileSource,omitempty"`
@@ -462,6 +572,48 @@ type RcvFileCompleteEvent struct {
 	ChatItem AChatItem `json:"chatItem"`
 }
 
+// RcvFileProgressEvent represents the progress of an XFTP file download
+type RcvFileProgressEvent struct {
+	User         User       `json:"user"`
+	ChatItem     *AChatItem `json:"chatItem_,omitempty"`
+	ReceivedSize int64      `json:"receivedSize"`
+	TotalSize    int64      `json:"totalSize"`
+}
+
+// RcvFileErrorEvent represents a failed file download
+type RcvFileErrorEvent struct {
+	User       User            `json:"user"`
+	ChatItem   *AChatItem      `json:"chatItem_,omitempty"`
+	AgentError json.RawMessage `json:"agentError,omitempty"`
+}
+
+// RcvFileSndCancelledEvent represents a file download cancelled by the sender
+type RcvFileSndCancelledEvent struct {
+	User     User      `json:"user"`
+	ChatItem AChatItem `json:"chatItem"`
+}
+
+// SndFileProgressEvent represents the progress of an XFTP file upload
+type SndFileProgressEvent struct {
+	User      User       `json:"user"`
+	ChatItem  *AChatItem `json:"chatItem_,omitempty"`
+	SentSize  int64      `json:"sentSize"`
+	TotalSize int64      `json:"totalSize"`
+}
+
+// SndFileCompleteEvent represents a completed XFTP file upload
+type SndFileCompleteEvent struct {
+	User     User      `json:"user"`
+	ChatItem AChatItem `json:"chatItem"`
+}
+
+// SndFileErrorEvent represents a failed XFTP file upload
+type SndFileErrorEvent struct {
+	User         User       `json:"user"`
+	ChatItem     *AChatItem `json:"chatItem_,omitempty"`
+	ErrorMessage string     `json:"errorMessage"`
+}
+
 // RcvFileDescrReadyEvent represents a file ready to be accepted for download
 type RcvFileDescrReadyEvent struct {
 	User            User      `json:"user"`
@@ -473,6 +625,34 @@ type RcvFileDescrReadyEvent struct {
 	} `json:"rcvFileTransfer"`
 }
 
+// CreatedLink is a SimpleX connection link, which has a short form in newer
+// versions
+type CreatedLink struct {
+	ConnFullLink  string `json:"connFullLink"`
+	ConnShortLink string `json:"connShortLink,omitempty"`
+}
+
+// String returns the short link if there is one, and the full link otherwise
+func (l CreatedLink) String() string {
+	if l.ConnShortLink != "" {
+		return l.ConnShortLink
+	}
+	return l.ConnFullLink
+}
+
+// UserJoinedGroupEvent represents us joining a group, e.g. via a group link
+type UserJoinedGroupEvent struct {
+	User      User      `json:"user"`
+	GroupInfo GroupInfo `json:"groupInfo"`
+}
+
+// AcceptingBusinessRequestEvent represents a contact request to a business
+// address being accepted as a business chat
+type AcceptingBusinessRequestEvent struct {
+	User      User      `json:"user"`
+	GroupInfo GroupInfo `json:"groupInfo"`
+}
+
 // ReceivedContactRequestEvent represents an incoming contact request
 type ReceivedContactRequestEvent struct {
 	User           User               `json:"user"`
@@ -16,18 +16,48 @@
 
 package simplexid
 
-import "go.mau.fi/util/jsontime"
+import (
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
 
 // PortalMetadata stores extra data about a portal room.
 type PortalMetadata struct {
 	// LastSync tracks the last time the portal info was synced.
 	LastSync jsontime.Unix `json:"last_sync,omitempty"`
+	// FilePolicy overrides the bridge-wide file receive policy for this portal.
+	FilePolicy *FilePolicyOverride `json:"file_policy,omitempty"`
+	// DeleteForMe makes Matrix redactions delete messages only locally
+	// instead of for everyone.
+	DeleteForMe bool `json:"delete_for_me,omitempty"`
+}
+
+// FilePolicyOverride stores per-portal overrides of the file receive policy.
+// Nil fields fall back to the bridge config.
+type FilePolicyOverride struct {
+	// AutoAccept overrides whether files are downloaded automatically.
+	AutoAccept *bool `json:"auto_accept,omitempty"`
+	// MaxSize overrides the maximum size of automatically downloaded files.
+	MaxSize *int64 `json:"max_size,omitempty"`
+	// AllowedTypes overrides the allowed MIME types and extensions. Entries
+	// starting with a dot are extensions, everything else is a MIME pattern.
+	AllowedTypes []string `json:"allowed_types,omitempty"`
 }
 
 // MessageMetadata stores extra data about a message.
 type MessageMetadata struct {
 	// HasFile indicates the message has a file attachment.
 	HasFile bool `json:"has_file,omitempty"`
+	// PendingFileID is the SimpleX file ID of an attachment that wasn't
+	// downloaded automatically and can still be downloaded on demand.
+	PendingFileID int64 `json:"pending_file_id,omitempty"`
+}
+
+// ReactionMetadata stores extra data about a reaction.
+type ReactionMetadata struct {
+	// FallbackItemID is the ID of the "reacted with" message sent instead of
+	// a reaction that SimpleX doesn't support.
+	FallbackItemID int64 `json:"fallback_item_id,omitempty"`
 }
 
 // UserLoginMetadata stores extra data about a user login.
@@ -38,8 +68,16 @@ type UserLoginMetadata struct {
 	DBPath string `json:"db_path,omitempty"`
 	// Managed indicates the bridge manages the simplex-chat process.
 	Managed bool `json:"managed,omitempty"`
+	// EncryptedDBKey is the database passphrase (managed mode only), encrypted
+	// with the bridge's database_key_secret.
+	EncryptedDBKey string `json:"encrypted_db_key,omitempty"`
 	// ChatsSynced indicates whether contacts/groups have been enumerated.
 	ChatsSynced bool `json:"chats_synced,omitempty"`
+	// NoteFolderID is the ID of the user's local notes chat.
+	NoteFolderID int64 `json:"note_folder_id,omitempty"`
+	// DeletedChats are the portals deleted from Matrix only for the user,
+	// whose chats still exist on SimpleX and mustn't be recreated on sync.
+	DeletedChats []networkid.PortalID `json:"deleted_chats,omitempty"`
 }
 
 // GhostMetadata stores extra data about a ghost user.
@@ -38,6 +38,12 @@ func MakeDMPortalID(contactID int64) networkid.PortalID {
 	return networkid.PortalID(fmt.Sprintf("d:%d", contactID))
 }
 
+// MakeNotesPortalID creates a portal ID for a local notes chat.
+// Format: "n:<noteFolderId>"
+func MakeNotesPortalID(noteFolderID int64) networkid.PortalID {
+	return networkid.PortalID(fmt.Sprintf("n:%d", noteFolderID))
+}
+
 // ParsePortalID parses a portal ID and returns the chat type and ID.
 func ParsePortalID(portalID networkid.PortalID) (simplexclient.ChatType, int64, error) {
 	s := string(portalID)
@@ -53,6 +59,12 @@ func ParsePortalID(portalID networkid.PortalID) (simplexclient.ChatType, int64,
 			return "", 0, fmt.Errorf("invalid DM portal ID %q: %w", s, err)
 		}
 		return simplexclient.ChatTypeDirect, id, nil
+	} else if strings.HasPrefix(s, "n:") {
+		id, err := strconv.ParseInt(s[2:], 10, 64)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid notes portal ID %q: %w", s, err)
+		}
+		return simplexclient.ChatTypeLocal, id, nil
 	}
 	return "", 0, fmt.Errorf("unknown portal ID format: %q", s)
 }